@@ -26,6 +26,7 @@ func main() {
 
 	router := http.NewServeMux()
 	router.Handle("/", handle(counter))
+	router.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {})
 
 	server := http.Server{
 		Addr:              ":" + port,
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// adminClient talks to the admin api of a running load balancer
+type adminClient struct {
+	addr  string
+	token string
+	route string
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+func (c *adminClient) do(method, path string, result any) error {
+	request, err := http.NewRequest(method, strings.TrimSuffix(c.addr, "/")+path, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	request.Header.Set("Authorization", "Bearer "+c.token)
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("calling admin api: %w", err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &failure) == nil && failure.Error != "" {
+			return fmt.Errorf("admin api answered %d: %s", response.StatusCode, failure.Error)
+		}
+
+		return fmt.Errorf("admin api answered %d", response.StatusCode)
+	}
+
+	if err = json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+
+	return nil
+}
+
+// withRoute limits the request to the route of the -route flag
+func (c *adminClient) withRoute(path string) string {
+	if c.route == "" {
+		return path
+	}
+
+	return path + "?route=" + url.QueryEscape(c.route)
+}
+
+func backendPath(id, action string) string {
+	return "/admin/backends/" + url.PathEscape(id) + "/" + action
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"lb-9000/lb-9000/internal/admin"
+	"lb-9000/lb-9000/internal/config"
+	"lb-9000/lb-9000/internal/store"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"slices"
+	"time"
+)
+
+// secrets are redacted by config show
+var secrets = []string{"STORE_PASSWORD", "ADMIN_TOKEN", "AFFINITY_COOKIE_KEY"}
+
+func listBackends(client *adminClient, out *printer) error {
+	var backends []admin.Backend
+	if err := client.do(http.MethodGet, client.withRoute("/admin/backends"), &backends); err != nil {
+		return err
+	}
+
+	return out.backends(backends, backends)
+}
+
+func changeBackend(client *adminClient, out *printer, id, action string) error {
+	var changed []admin.Backend
+	if err := client.do(http.MethodPost, client.withRoute(backendPath(id, action)), &changed); err != nil {
+		return err
+	}
+
+	return out.backends(changed, changed)
+}
+
+func showLeader(client *adminClient, out *printer) error {
+	var leader map[string]string
+	if err := client.do(http.MethodGet, "/admin/leader", &leader); err != nil {
+		return err
+	}
+
+	if leader["leader"] == "" {
+		leader["leader"] = "none"
+	}
+
+	return out.table(leader, []string{"LEADER"}, [][]string{{leader["leader"]}})
+}
+
+func showConfig(path string, out *printer) error {
+	cfg, err := config.Parse(path)
+	if err != nil {
+		return err
+	}
+
+	values := map[string]any{}
+	var rows [][]string
+
+	value := reflect.ValueOf(cfg).Elem()
+	for i := range value.NumField() {
+		key := value.Type().Field(i).Tag.Get("mapstructure")
+		field := value.Field(i).Interface()
+
+		if slices.Contains(secrets, key) && !value.Field(i).IsZero() {
+			field = "<redacted>"
+		}
+
+		if duration, ok := field.(time.Duration); ok {
+			field = duration.String()
+		}
+
+		values[key] = field
+		rows = append(rows, []string{key, fmt.Sprint(field)})
+	}
+
+	return out.table(values, []string{"KEY", "VALUE"}, rows)
+}
+
+// dumpStore reads the records of every route as they are stored, without going through a load balancer
+func dumpStore(path string, out *printer) error {
+	cfg, err := config.Parse(path)
+	if err != nil {
+		return err
+	}
+
+	routes, err := config.ParseRoutes(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	records := map[string][]json.RawMessage{}
+	var views []admin.Backend
+
+	for _, route := range routes {
+		backends, err := store.Get(route.Config, "", slog.Default()).All(ctx)
+		if err != nil {
+			return fmt.Errorf("reading store of route '%s': %w", route.Name, err)
+		}
+
+		records[route.Name] = make([]json.RawMessage, 0, len(backends))
+		for _, b := range backends {
+			record, err := b.MarshalBinary()
+			if err != nil {
+				return fmt.Errorf("marshaling backend: %w", err)
+			}
+
+			records[route.Name] = append(records[route.Name], record)
+			views = append(views, admin.NewBackend(route.Name, b))
+		}
+	}
+
+	return out.backends(records, views)
+}
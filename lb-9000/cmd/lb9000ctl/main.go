@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `usage: lb9000ctl [flags] <command>
+
+commands:
+  backends list             list the backends with their counts and states
+  backends drain <id>       drain a backend
+  backends cordon <id>      take a backend out of the selection
+  backends uncordon <id>    put a cordoned backend back into the selection
+  leader                    show the instance id of the current leader
+  config show               show the configuration, secrets are redacted
+  store dump                dump the records of the store, read directly with the configuration
+
+flags:
+`
+
+var errUsage = errors.New("invalid command")
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, errUsage) {
+			_, _ = fmt.Fprintln(os.Stderr, "error:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("lb9000ctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	addr := flags.String("addr", env("LB9000_ADDR", "http://localhost:8081"), "address of the admin api ($LB9000_ADDR)")
+	token := flags.String("token", env("LB9000_TOKEN", os.Getenv("ADMIN_TOKEN")), "bearer token of the admin api ($LB9000_TOKEN)")
+	configPath := flags.String("config", "lb-9000/internal/config/.env", "config file used by config show and store dump")
+	output := flags.String("o", "table", "output format, table or json")
+	route := flags.String("route", "", "limit backend commands to a single route")
+
+	flags.Usage = func() {
+		_, _ = fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if *output != "table" && *output != "json" {
+		_, _ = fmt.Fprintf(stderr, "unknown output format '%s'\n", *output)
+		return errUsage
+	}
+
+	out := &printer{writer: stdout, json: *output == "json"}
+	client := &adminClient{addr: *addr, token: *token, route: *route}
+
+	switch command := flags.Args(); {
+	case matches(command, "backends", "list"):
+		return listBackends(client, out)
+	case matches(command, "backends", "drain", "*"):
+		return changeBackend(client, out, command[2], "drain")
+	case matches(command, "backends", "cordon", "*"):
+		return changeBackend(client, out, command[2], "cordon")
+	case matches(command, "backends", "uncordon", "*"):
+		return changeBackend(client, out, command[2], "uncordon")
+	case matches(command, "leader"):
+		return showLeader(client, out)
+	case matches(command, "config", "show"):
+		return showConfig(*configPath, out)
+	case matches(command, "store", "dump"):
+		return dumpStore(*configPath, out)
+	default:
+		flags.Usage()
+		return errUsage
+	}
+}
+
+// matches compares the command with the pattern, * matches any single argument
+func matches(command []string, pattern ...string) bool {
+	if len(command) != len(pattern) {
+		return false
+	}
+
+	for i := range pattern {
+		if pattern[i] != "*" && pattern[i] != command[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func env(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+
+	return fallback
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"lb-9000/lb-9000/internal/admin"
+	"lb-9000/lb-9000/internal/backend"
+	"lb-9000/lb-9000/internal/config"
+	"lb-9000/lb-9000/internal/store"
+	"lb-9000/lb-9000/internal/store/memory"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type staticElector string
+
+func (e staticElector) Leader(context.Context) (string, error) {
+	return string(e), nil
+}
+
+func TestRun(t *testing.T) {
+	backendStore := memory.New(slog.Default())
+	assert.NoError(t, backendStore.Add(t.Context(), backend.NewBackend("10.0.0.1:8080", "one")))
+
+	mux := http.NewServeMux()
+	stores := map[string]store.Store{"default": backendStore}
+	admin.New(stores, nil, staticElector("lb-0"), slog.Default(), &config.Config{AdminToken: "secret"}).Register(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctl := func(args ...string) (string, error) {
+		var stdout bytes.Buffer
+		err := run(append([]string{"-addr", server.URL, "-token", "secret", "-config", "../../internal/config/.env"}, args...), &stdout, io.Discard)
+		return stdout.String(), err
+	}
+
+	out, err := ctl("backends", "cordon", "10.0.0.1:8080")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "cordoned")
+
+	out, err = ctl("-o", "json", "backends", "list")
+	assert.NoError(t, err)
+
+	var backends []admin.Backend
+	assert.NoError(t, json.Unmarshal([]byte(out), &backends))
+	assert.Len(t, backends, 1)
+	assert.True(t, backends[0].Cordoned)
+
+	out, err = ctl("leader")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "lb-0")
+
+	out, err = ctl("config", "show")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "SPEC_CONTAINER_PORT")
+
+	_, err = ctl("backends", "drain", "missing")
+	assert.ErrorContains(t, err, "not found")
+
+	_, err = ctl("backends")
+	assert.ErrorIs(t, err, errUsage)
+}
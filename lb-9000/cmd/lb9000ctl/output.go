@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"lb-9000/lb-9000/internal/admin"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// printer writes either the raw value as json or the rows as a table
+type printer struct {
+	writer io.Writer
+	json   bool
+}
+
+func (p *printer) table(value any, header []string, rows [][]string) error {
+	if p.json {
+		encoder := json.NewEncoder(p.writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+
+	writer := tabwriter.NewWriter(p.writer, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, strings.Join(header, "\t"))
+	for _, row := range rows {
+		_, _ = fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+
+	return writer.Flush()
+}
+
+func (p *printer) backends(value any, backends []admin.Backend) error {
+	rows := make([][]string, 0, len(backends))
+	for _, b := range backends {
+		rows = append(rows, []string{
+			b.Route,
+			b.ID,
+			b.Name,
+			strconv.FormatInt(b.Count, 10),
+			strconv.Itoa(b.Weight),
+			states(b),
+		})
+	}
+
+	return p.table(value, []string{"ROUTE", "ID", "NAME", "REQUESTS", "WEIGHT", "STATE"}, rows)
+}
+
+func states(b admin.Backend) string {
+	var states []string
+
+	if b.Available {
+		states = append(states, "available")
+	}
+	if !b.Healthy {
+		states = append(states, "unhealthy")
+	}
+	if b.Cordoned {
+		states = append(states, "cordoned")
+	}
+	if b.Ejected {
+		states = append(states, "ejected until "+b.EjectedUntil.Format(time.RFC3339))
+	}
+	if b.Draining {
+		states = append(states, "draining since "+b.DrainingSince.Format(time.RFC3339))
+	}
+	if len(states) == 0 {
+		states = append(states, "saturated")
+	}
+
+	return strings.Join(states, ",")
+}
@@ -0,0 +1,206 @@
+package accesslog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"lb-9000/lb-9000/internal/config"
+	"lb-9000/lb-9000/internal/requestid"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Logger writes one entry per completed request
+type Logger struct {
+	sampleRate float64
+	instanceID string
+
+	lock   sync.Mutex
+	writer io.Writer
+	slog   *slog.Logger
+}
+
+// New returns nil when ACCESS_LOG_FORMAT is not set, which disables the access log
+func New(cfg *config.Config, instanceID string) (*Logger, error) {
+	if cfg.AccessLogFormat == "" {
+		return nil, nil
+	}
+
+	var writer io.Writer
+	switch cfg.AccessLogOutput {
+	case "", "stdout":
+		writer = os.Stdout
+	case "stderr":
+		writer = os.Stderr
+	default:
+		file, err := os.OpenFile(cfg.AccessLogOutput, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("opening access log: %w", err)
+		}
+		writer = file
+	}
+
+	return newLogger(writer, cfg.AccessLogFormat, cfg.AccessLogSampleRate, instanceID)
+}
+
+func newLogger(writer io.Writer, format string, sampleRate float64, instanceID string) (*Logger, error) {
+	logger := &Logger{
+		sampleRate: sampleRate,
+		instanceID: instanceID,
+		writer:     writer,
+	}
+
+	options := &slog.HandlerOptions{
+		// the start of the request is part of the entry already
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if len(groups) == 0 && attr.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return attr
+		},
+	}
+
+	switch format {
+	case "json":
+		logger.slog = slog.New(slog.NewJSONHandler(writer, options))
+	case "logfmt":
+		logger.slog = slog.New(slog.NewTextHandler(writer, options))
+	case "combined":
+	default:
+		return nil, fmt.Errorf("unknown access log format '%s'", format)
+	}
+
+	return logger, nil
+}
+
+type entryKey struct{}
+
+// entry collects what is only known further down the chain
+type entry struct {
+	backend string
+}
+
+// SetBackend records the backend elected for the request
+func SetBackend(ctx context.Context, backend string) {
+	if e, ok := ctx.Value(entryKey{}).(*entry); ok {
+		e.backend = backend
+	}
+}
+
+// Middleware logs the request once the response is complete
+func (l *Logger) Middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		e := &entry{}
+		recorder := &responseRecorder{ResponseWriter: writer, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, request.WithContext(context.WithValue(request.Context(), entryKey{}, e)))
+
+		// server errors are always logged, whatever the sample rate
+		if recorder.status < http.StatusInternalServerError && rand.Float64() >= l.sampleRate {
+			return
+		}
+
+		l.write(request, recorder, e, start)
+	})
+}
+
+func (l *Logger) write(request *http.Request, recorder *responseRecorder, e *entry, start time.Time) {
+	duration := time.Since(start)
+	requestID := requestid.From(request.Context())
+
+	if l.slog != nil {
+		l.slog.Info(
+			"access",
+			"start", start,
+			"method", request.Method,
+			"path", request.URL.Path,
+			"status", recorder.status,
+			"bytes", recorder.bytes,
+			"duration", duration,
+			"backend", e.backend,
+			"instanceId", l.instanceID,
+			"requestId", requestID,
+		)
+		return
+	}
+
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// combined log format, followed by the duration in milliseconds, the backend, the instance and the request id
+	_, _ = fmt.Fprintf(
+		l.writer,
+		"%s - - [%s] %s %d %d %s %s %d %s %s %s\n",
+		host,
+		start.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(request.Method+" "+request.URL.RequestURI()+" "+request.Proto),
+		recorder.status,
+		recorder.bytes,
+		quote(request.Referer()),
+		quote(request.UserAgent()),
+		duration.Milliseconds(),
+		quote(e.backend),
+		quote(l.instanceID),
+		quote(requestID),
+	)
+}
+
+func quote(value string) string {
+	if value == "" {
+		return `"-"`
+	}
+
+	return strconv.Quote(value)
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	// informational responses are followed by the final one
+	if !r.wroteHeader && status >= http.StatusOK {
+		r.status = status
+		r.wroteHeader = true
+	}
+
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += int64(n)
+
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the deadlines of the underlying writer
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
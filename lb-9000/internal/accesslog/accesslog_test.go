@@ -0,0 +1,72 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"lb-9000/lb-9000/internal/requestid"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serve(t *testing.T, logger *Logger, status int) {
+	handler := requestid.Middleware(logger.Middleware(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		SetBackend(request.Context(), "10.0.0.1:8080")
+		writer.WriteHeader(status)
+		_, _ = writer.Write([]byte("hello"))
+	})))
+
+	request := httptest.NewRequest(http.MethodGet, "/jobs/1?full=true", nil)
+	request.Header.Set("X-Request-ID", "abc")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+}
+
+func TestFormats(t *testing.T) {
+	var out bytes.Buffer
+
+	logger, err := newLogger(&out, "json", 1, "lb-0")
+	assert.NoError(t, err)
+	serve(t, logger, http.StatusCreated)
+
+	var entry map[string]any
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &entry))
+	assert.Equal(t, "/jobs/1", entry["path"])
+	assert.Equal(t, 201.0, entry["status"])
+	assert.Equal(t, 5.0, entry["bytes"])
+	assert.Equal(t, "10.0.0.1:8080", entry["backend"])
+	assert.Equal(t, "lb-0", entry["instanceId"])
+	assert.Equal(t, "abc", entry["requestId"])
+
+	out.Reset()
+	logger, err = newLogger(&out, "logfmt", 1, "lb-0")
+	assert.NoError(t, err)
+	serve(t, logger, http.StatusOK)
+	assert.Contains(t, out.String(), "method=GET path=/jobs/1 status=200 bytes=5")
+
+	out.Reset()
+	logger, err = newLogger(&out, "combined", 1, "lb-0")
+	assert.NoError(t, err)
+	serve(t, logger, http.StatusOK)
+	assert.True(t, strings.HasPrefix(out.String(), "192.0.2.1 - - ["), out.String())
+	assert.Contains(t, out.String(), `"GET /jobs/1?full=true HTTP/1.1" 200 5 "-" "-" `)
+	assert.True(t, strings.HasSuffix(out.String(), ` "10.0.0.1:8080" "lb-0" "abc"`+"\n"), out.String())
+
+	_, err = newLogger(&out, "xml", 1, "lb-0")
+	assert.Error(t, err)
+}
+
+func TestSampling(t *testing.T) {
+	var out bytes.Buffer
+
+	logger, err := newLogger(&out, "logfmt", 0, "lb-0")
+	assert.NoError(t, err)
+
+	serve(t, logger, http.StatusOK)
+	assert.Empty(t, out.String())
+
+	serve(t, logger, http.StatusBadGateway)
+	assert.Contains(t, out.String(), "status=502")
+}
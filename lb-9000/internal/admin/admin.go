@@ -0,0 +1,282 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"lb-9000/lb-9000/internal/backend"
+	"lb-9000/lb-9000/internal/config"
+	"lb-9000/lb-9000/internal/store"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Elector tells which instance currently leads
+type Elector interface {
+	Leader(ctx context.Context) (string, error)
+}
+
+// Queue reports the number of waiting requests of a route and the capacity of its queue
+type Queue interface {
+	QueueDepth() (int, int)
+}
+
+// API lets operators inspect and change the pools, every request needs the bearer token
+type API struct {
+	stores  map[string]store.Store
+	queues  map[string]Queue
+	elector Elector
+	logger  *slog.Logger
+	token   string
+}
+
+// New returns nil when ADMIN_TOKEN is not set, which disables the API.
+// stores and queues hold the store and the queue of every route
+func New(stores map[string]store.Store, queues map[string]Queue, elector Elector, logger *slog.Logger, cfg *config.Config) *API {
+	if cfg.AdminToken == "" {
+		return nil
+	}
+
+	return &API{
+		stores:  stores,
+		queues:  queues,
+		elector: elector,
+		logger:  logger,
+		token:   cfg.AdminToken,
+	}
+}
+
+// QueueDepth is the view of a queue returned by the API
+type QueueDepth struct {
+	Depth int `json:"depth"`
+	Size  int `json:"size"`
+}
+
+// Backend is the view of a backend returned by the API
+type Backend struct {
+	Route         string    `json:"route"`
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Count         int64     `json:"count"`
+	Weight        int       `json:"weight"`
+	ManualWeight  int       `json:"manualWeight,omitempty"`
+	MaxRequests   int       `json:"maxRequests,omitempty"`
+	Available     bool      `json:"available"`
+	Healthy       bool      `json:"healthy"`
+	Cordoned      bool      `json:"cordoned"`
+	Ejected       bool      `json:"ejected"`
+	EjectedUntil  time.Time `json:"ejectedUntil,omitzero"`
+	Draining      bool      `json:"draining"`
+	DrainingSince time.Time `json:"drainingSince,omitzero"`
+}
+
+func NewBackend(route string, b *backend.Backend) Backend {
+	return Backend{
+		Route:         route,
+		ID:            b.URL(),
+		Name:          b.Name(),
+		Count:         b.Count(),
+		Weight:        b.Weight(),
+		ManualWeight:  b.ManualWeight(),
+		MaxRequests:   b.MaxRequests(),
+		Available:     b.Available(),
+		Healthy:       b.Healthy(),
+		Cordoned:      b.Cordoned(),
+		Ejected:       b.Ejected(),
+		EjectedUntil:  b.EjectedUntil(),
+		Draining:      b.Draining(),
+		DrainingSince: b.DrainingSince(),
+	}
+}
+
+// Register adds the admin routes to mux, it does nothing when the API is disabled
+func (a *API) Register(mux *http.ServeMux) {
+	if a == nil {
+		return
+	}
+
+	mux.Handle("GET /admin/backends", a.authenticate(a.listBackends))
+	mux.Handle("POST /admin/backends/{id}/drain", a.authenticate(a.update(func(b *backend.Backend) {
+		b.Drain(time.Now())
+	})))
+	mux.Handle("POST /admin/backends/{id}/cordon", a.authenticate(a.update(func(b *backend.Backend) {
+		b.Cordon(true)
+	})))
+	mux.Handle("POST /admin/backends/{id}/uncordon", a.authenticate(a.update(func(b *backend.Backend) {
+		b.Cordon(false)
+	})))
+	mux.Handle("PUT /admin/backends/{id}/weight", a.authenticate(a.setWeight))
+	mux.Handle("GET /admin/leader", a.authenticate(a.leader))
+	mux.Handle("GET /admin/queue", a.authenticate(a.queueDepth))
+}
+
+func (a *API) authenticate(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		token, ok := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			writer.Header().Set("WWW-Authenticate", "Bearer")
+			a.writeError(writer, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+
+		next(writer, request)
+	})
+}
+
+func (a *API) listBackends(writer http.ResponseWriter, request *http.Request) {
+	routes, ok := a.routes(writer, request)
+	if !ok {
+		return
+	}
+
+	views := []Backend{}
+	for _, route := range routes {
+		backends, err := a.stores[route].All(request.Context())
+		if err != nil {
+			a.logger.Error("cannot list backends", "route", route, "error", err)
+			a.writeError(writer, http.StatusInternalServerError, "cannot list backends")
+			return
+		}
+
+		for _, b := range backends {
+			views = append(views, NewBackend(route, b))
+		}
+	}
+
+	a.write(writer, http.StatusOK, views)
+}
+
+// routes returns the route of the route query parameter or all routes, sorted by name
+func (a *API) routes(writer http.ResponseWriter, request *http.Request) ([]string, bool) {
+	if route := request.URL.Query().Get("route"); route != "" {
+		if _, ok := a.stores[route]; !ok {
+			a.writeError(writer, http.StatusNotFound, fmt.Sprintf("route '%s' not found", route))
+			return nil, false
+		}
+
+		return []string{route}, true
+	}
+
+	return slices.Sorted(maps.Keys(a.stores)), true
+}
+
+// update applies fn to the backend of the path and answers with the result
+func (a *API) update(fn func(b *backend.Backend)) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		a.apply(writer, request, fn)
+	}
+}
+
+func (a *API) setWeight(writer http.ResponseWriter, request *http.Request) {
+	var body struct {
+		Weight *int `json:"weight"`
+	}
+
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil || body.Weight == nil || *body.Weight < 0 {
+		a.writeError(writer, http.StatusBadRequest, `expected {"weight": n} with n >= 0, 0 resets the weight`)
+		return
+	}
+
+	a.apply(writer, request, func(b *backend.Backend) {
+		b.SetManualWeight(*body.Weight)
+	})
+}
+
+// apply changes the backend in every route it belongs to, or only in the route of the query
+func (a *API) apply(writer http.ResponseWriter, request *http.Request, fn func(b *backend.Backend)) {
+	id := request.PathValue("id")
+	ctx := request.Context()
+
+	routes, ok := a.routes(writer, request)
+	if !ok {
+		return
+	}
+
+	changed := []Backend{}
+	for _, route := range routes {
+		existing, err := a.stores[route].Get(ctx, id)
+		if err != nil {
+			a.logger.Error("cannot get backend", "route", route, "id", id, "error", err)
+			a.writeError(writer, http.StatusInternalServerError, "cannot get backend")
+			return
+		}
+
+		if existing == nil {
+			continue
+		}
+
+		var updated *backend.Backend
+		if err = a.stores[route].Update(ctx, id, func(b *backend.Backend) {
+			fn(b)
+			updated = b
+		}); err != nil {
+			a.logger.Error("cannot update backend", "route", route, "id", id, "error", err)
+			a.writeError(writer, http.StatusInternalServerError, "cannot update backend")
+			return
+		}
+
+		a.logger.Info("backend changed through the admin api", "route", route, "id", id, "action", request.URL.Path)
+		changed = append(changed, NewBackend(route, updated))
+	}
+
+	if len(changed) == 0 {
+		a.writeError(writer, http.StatusNotFound, fmt.Sprintf("backend '%s' not found", id))
+		return
+	}
+
+	a.write(writer, http.StatusOK, changed)
+}
+
+func (a *API) leader(writer http.ResponseWriter, request *http.Request) {
+	leader, err := a.elector.Leader(request.Context())
+	if err != nil {
+		a.logger.Error("cannot get leader", "error", err)
+		a.writeError(writer, http.StatusInternalServerError, "cannot get leader")
+		return
+	}
+
+	a.write(writer, http.StatusOK, map[string]string{"leader": leader})
+}
+
+// queueDepth answers with the queues of the routes and their total
+func (a *API) queueDepth(writer http.ResponseWriter, request *http.Request) {
+	routes, ok := a.routes(writer, request)
+	if !ok {
+		return
+	}
+
+	var total QueueDepth
+	depths := map[string]QueueDepth{}
+
+	for _, route := range routes {
+		queue, ok := a.queues[route]
+		if !ok {
+			continue
+		}
+
+		depth, size := queue.QueueDepth()
+		depths[route] = QueueDepth{Depth: depth, Size: size}
+		total.Depth += depth
+		total.Size += size
+	}
+
+	a.write(writer, http.StatusOK, map[string]any{"depth": total.Depth, "size": total.Size, "routes": depths})
+}
+
+func (a *API) write(writer http.ResponseWriter, status int, body any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+
+	if err := json.NewEncoder(writer).Encode(body); err != nil {
+		a.logger.Error("error writing admin response", "error", err)
+	}
+}
+
+func (a *API) writeError(writer http.ResponseWriter, status int, message string) {
+	a.write(writer, status, map[string]any{"status": status, "error": message})
+}
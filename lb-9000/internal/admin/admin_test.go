@@ -0,0 +1,99 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"lb-9000/lb-9000/internal/backend"
+	"lb-9000/lb-9000/internal/config"
+	"lb-9000/lb-9000/internal/store"
+	"lb-9000/lb-9000/internal/store/memory"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type staticQueue struct {
+	depth, size int
+}
+
+func (q staticQueue) QueueDepth() (int, int) {
+	return q.depth, q.size
+}
+
+type staticElector string
+
+func (e staticElector) Leader(context.Context) (string, error) {
+	return string(e), nil
+}
+
+func TestAPI(t *testing.T) {
+	backendStore := memory.New(slog.Default())
+	assert.NoError(t, backendStore.Add(t.Context(), backend.NewBackend("10.0.0.1:8080", "one")))
+
+	stores := map[string]store.Store{"default": backendStore, "other": memory.New(slog.Default())}
+
+	queues := map[string]Queue{"default": staticQueue{depth: 2, size: 10}, "other": staticQueue{size: 5}}
+
+	assert.Nil(t, New(stores, queues, staticElector("lb-0"), slog.Default(), &config.Config{}))
+
+	mux := http.NewServeMux()
+	New(stores, queues, staticElector("lb-0"), slog.Default(), &config.Config{AdminToken: "secret"}).Register(mux)
+
+	call := func(method, path, token, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/admin/backends", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/admin/backends", "wrong", "").Code)
+
+	recorder := call(http.MethodGet, "/admin/leader", "secret", "")
+	assert.JSONEq(t, `{"leader": "lb-0"}`, recorder.Body.String())
+
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/admin/queue", "", "").Code)
+
+	recorder = call(http.MethodGet, "/admin/queue", "secret", "")
+	assert.JSONEq(t, `{"depth": 2, "size": 15, "routes": {"default": {"depth": 2, "size": 10}, "other": {"depth": 0, "size": 5}}}`, recorder.Body.String())
+
+	recorder = call(http.MethodPost, "/admin/backends/10.0.0.1:8080/cordon", "secret", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var updated []Backend
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &updated))
+	assert.Len(t, updated, 1)
+	assert.Equal(t, "default", updated[0].Route)
+	assert.True(t, updated[0].Cordoned)
+	assert.False(t, updated[0].Available)
+
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, "/admin/backends/10.0.0.1:8080/uncordon", "secret", "").Code)
+	assert.Equal(t, http.StatusOK, call(http.MethodPut, "/admin/backends/10.0.0.1:8080/weight", "secret", `{"weight": 5}`).Code)
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPut, "/admin/backends/10.0.0.1:8080/weight", "secret", `{}`).Code)
+	assert.Equal(t, http.StatusNotFound, call(http.MethodPost, "/admin/backends/missing/drain", "secret", "").Code)
+	assert.Equal(t, http.StatusNotFound, call(http.MethodPost, "/admin/backends/10.0.0.1:8080/drain?route=other", "secret", "").Code)
+	assert.Equal(t, http.StatusNotFound, call(http.MethodGet, "/admin/backends?route=missing", "secret", "").Code)
+
+	recorder = call(http.MethodGet, "/admin/backends", "secret", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var backends []Backend
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &backends))
+	assert.Len(t, backends, 1)
+	assert.Equal(t, 5, backends[0].Weight)
+	assert.True(t, backends[0].Available)
+
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, "/admin/backends/10.0.0.1:8080/drain", "secret", "").Code)
+
+	drained, err := backendStore.Get(t.Context(), "10.0.0.1:8080")
+	assert.NoError(t, err)
+	assert.True(t, drained.Draining())
+}
@@ -0,0 +1,112 @@
+package affinity
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"lb-9000/lb-9000/internal/config"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cookie pins clients to a backend with a signed cookie naming it
+type Cookie struct {
+	name string
+	ttl  time.Duration
+	key  []byte
+	now  func() time.Time
+}
+
+// New returns nil when no cookie name or signing key is configured, which disables affinity
+func New(cfg *config.Config) *Cookie {
+	if cfg.AffinityCookieName == "" || cfg.AffinityCookieKey == "" {
+		return nil
+	}
+
+	return &Cookie{
+		name: cfg.AffinityCookieName,
+		ttl:  cfg.AffinityCookieTTL,
+		key:  []byte(cfg.AffinityCookieKey),
+		now:  time.Now,
+	}
+}
+
+// Backend returns the id of the backend named by a valid cookie on the request
+func (c *Cookie) Backend(request *http.Request) (string, bool) {
+	cookie, err := request.Cookie(c.name)
+	if err != nil {
+		return "", false
+	}
+
+	return c.verify(cookie.Value)
+}
+
+// Set adds the cookie for the backend to the response
+func (c *Cookie) Set(response *http.Response, id string) {
+	expires := c.now().Add(c.ttl)
+
+	cookie := &http.Cookie{
+		Name:     c.name,
+		Value:    c.sign(id, expires),
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   int(c.ttl.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	response.Header.Add("Set-Cookie", cookie.String())
+}
+
+// sign encodes the value as <id>.<expiry>.<signature>, the id is base64 encoded because it contains dots
+func (c *Cookie) sign(id string, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(id)) + "." + strconv.FormatInt(expires.Unix(), 10)
+
+	return payload + "." + base64.RawURLEncoding.EncodeToString(c.mac(payload))
+}
+
+func (c *Cookie) verify(value string) (string, bool) {
+	payload, signature, ok := cutLast(value, ".")
+	if !ok {
+		return "", false
+	}
+
+	decodedSignature, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(decodedSignature, c.mac(payload)) {
+		return "", false
+	}
+
+	encodedID, rawExpiry, ok := strings.Cut(payload, ".")
+	if !ok {
+		return "", false
+	}
+
+	expiry, err := strconv.ParseInt(rawExpiry, 10, 64)
+	if err != nil || c.now().After(time.Unix(expiry, 0)) {
+		return "", false
+	}
+
+	id, err := base64.RawURLEncoding.DecodeString(encodedID)
+	if err != nil {
+		return "", false
+	}
+
+	return string(id), true
+}
+
+func (c *Cookie) mac(payload string) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(payload))
+
+	return mac.Sum(nil)
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+
+	return s, "", false
+}
@@ -0,0 +1,59 @@
+package affinity
+
+import (
+	"lb-9000/lb-9000/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCookie(t *testing.T) {
+	assert.Nil(t, New(&config.Config{AffinityCookieName: "lb"}))
+
+	cookie := New(&config.Config{
+		AffinityCookieName: "lb",
+		AffinityCookieTTL:  time.Hour,
+		AffinityCookieKey:  "secret",
+	})
+
+	response := &http.Response{Header: http.Header{}}
+	cookie.Set(response, "10.244.0.6")
+
+	setCookie := response.Cookies()
+	assert.Len(t, setCookie, 1)
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.AddCookie(setCookie[0])
+
+	id, ok := cookie.Backend(request)
+	assert.True(t, ok)
+	assert.Equal(t, "10.244.0.6", id)
+
+	// tampered
+	tampered := httptest.NewRequest(http.MethodGet, "/", nil)
+	tampered.AddCookie(&http.Cookie{Name: "lb", Value: "MTAuMjQ0LjAuNw" + setCookie[0].Value[14:]})
+
+	_, ok = cookie.Backend(tampered)
+	assert.False(t, ok)
+
+	// signed with another key
+	other := New(&config.Config{
+		AffinityCookieName: "lb",
+		AffinityCookieTTL:  time.Hour,
+		AffinityCookieKey:  "other",
+	})
+
+	_, ok = other.Backend(request)
+	assert.False(t, ok)
+
+	// expired
+	cookie.now = func() time.Time {
+		return time.Now().Add(2 * time.Hour)
+	}
+
+	_, ok = cookie.Backend(request)
+	assert.False(t, ok)
+}
@@ -3,19 +3,37 @@ package backend
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"sync/atomic"
+	"time"
 )
 
 type Backend struct {
-	count *atomic.Int64
-	id    string
-	name  string
+	count         *atomic.Int64
+	id            string
+	name          string
+	target        *url.URL
+	weight        int
+	manualWeight  int
+	maxRequests   int
+	unhealthy     bool
+	cordoned      bool
+	ejectedUntil  time.Time
+	drainingSince time.Time
 }
 
 type innerBackend struct {
-	URL   string `json:"id"`
-	Name  string `json:"name"`
-	Count int64  `json:"count"`
+	URL           string    `json:"id"`
+	Name          string    `json:"name"`
+	Target        string    `json:"target,omitempty"`
+	Count         int64     `json:"count"`
+	Weight        int       `json:"weight,omitempty"`
+	ManualWeight  int       `json:"manualWeight,omitempty"`
+	MaxRequests   int       `json:"maxRequests,omitempty"`
+	Unhealthy     bool      `json:"unhealthy,omitempty"`
+	Cordoned      bool      `json:"cordoned,omitempty"`
+	EjectedUntil  time.Time `json:"ejectedUntil,omitzero"`
+	DrainingSince time.Time `json:"drainingSince,omitzero"`
 }
 
 func (p *Backend) URL() string {
@@ -26,20 +44,159 @@ func (p *Backend) Name() string {
 	return p.name
 }
 
+// Target is where the requests of the backend are sent, it is nil when the orchestrator did not set one.
+// It is shared by all requests and must not be modified
+func (p *Backend) Target() *url.URL {
+	return p.target
+}
+
+func (p *Backend) SetTarget(target *url.URL) {
+	p.target = target
+}
+
 func (p *Backend) Count() int64 {
 	return p.count.Load()
 }
 
+// Weight is the relative capacity of the backend, it is at least 1.
+// A manual weight takes precedence over the one of the orchestrator
+func (p *Backend) Weight() int {
+	if p.manualWeight > 0 {
+		return p.manualWeight
+	}
+
+	return max(1, p.weight)
+}
+
+func (p *Backend) SetWeight(weight int) {
+	p.weight = weight
+}
+
+func (p *Backend) ManualWeight() int {
+	return p.manualWeight
+}
+
+// SetManualWeight overrides the weight until it is set to 0 again
+func (p *Backend) SetManualWeight(weight int) {
+	p.manualWeight = weight
+}
+
+// MaxRequests is the number of concurrent requests the backend can handle, 0 means unlimited
+func (p *Backend) MaxRequests() int {
+	return p.maxRequests
+}
+
+func (p *Backend) SetMaxRequests(maxRequests int) {
+	p.maxRequests = maxRequests
+}
+
+// Saturated reports whether the backend is at its concurrency limit
+func (p *Backend) Saturated() bool {
+	return p.maxRequests > 0 && p.Count() >= int64(p.maxRequests)
+}
+
+func (p *Backend) Healthy() bool {
+	return !p.unhealthy
+}
+
+func (p *Backend) SetHealthy(healthy bool) {
+	p.unhealthy = !healthy
+}
+
+func (p *Backend) Cordoned() bool {
+	return p.cordoned
+}
+
+// Cordon takes the backend out of the selection without removing it
+func (p *Backend) Cordon(cordoned bool) {
+	p.cordoned = cordoned
+}
+
+func (p *Backend) Ejected() bool {
+	return time.Now().Before(p.ejectedUntil)
+}
+
+func (p *Backend) EjectedUntil() time.Time {
+	return p.ejectedUntil
+}
+
+func (p *Backend) Eject(until time.Time) {
+	p.ejectedUntil = until
+}
+
+func (p *Backend) Draining() bool {
+	return !p.drainingSince.IsZero()
+}
+
+func (p *Backend) DrainingSince() time.Time {
+	return p.drainingSince
+}
+
+// Drain stops the backend from being elected, the record is kept
+// until its in-flight requests are finished
+func (p *Backend) Drain(since time.Time) {
+	if p.Draining() {
+		return
+	}
+
+	p.drainingSince = since
+}
+
+// Available reports whether the backend may be elected for new requests
+func (p *Backend) Available() bool {
+	return p.Healthy() && !p.Cordoned() && !p.Ejected() && !p.Draining() && !p.Saturated()
+}
+
+// Restore carries the runtime state of a previously stored record over,
+// so re-adding a known backend does not reset its counter or health.
+// A new pod may get the ip of an old one, it starts afresh
+func (p *Backend) Restore(previous *Backend) {
+	if previous.name != p.name {
+		return
+	}
+
+	p.count.Store(previous.Count())
+	p.manualWeight = previous.manualWeight
+	p.unhealthy = previous.unhealthy
+	p.cordoned = previous.cordoned
+	p.ejectedUntil = previous.ejectedUntil
+	p.drainingSince = previous.drainingSince
+}
+
+// Clone copies the record, the copy shares the counter of the original
+func (p *Backend) Clone() *Backend {
+	clone := *p
+	return &clone
+}
+
+// SetCount replaces the counter with the one a store keeps apart from the record
+func (p *Backend) SetCount(count int64) {
+	p.count.Store(max(0, count))
+}
+
 func (p *Backend) AddRequests(n int64) {
 	newCount := max(0, p.count.Load()+n)
 	p.count.Store(newCount)
 }
 
 func (p *Backend) MarshalBinary() (data []byte, err error) {
+	var target string
+	if p.target != nil {
+		target = p.target.String()
+	}
+
 	return json.Marshal(innerBackend{
-		URL:   p.id,
-		Name:  p.name,
-		Count: p.count.Load(),
+		URL:           p.id,
+		Name:          p.name,
+		Target:        target,
+		Count:         p.count.Load(),
+		Weight:        p.weight,
+		ManualWeight:  p.manualWeight,
+		MaxRequests:   p.maxRequests,
+		Unhealthy:     p.unhealthy,
+		Cordoned:      p.cordoned,
+		EjectedUntil:  p.ejectedUntil,
+		DrainingSince: p.drainingSince,
 	})
 }
 
@@ -51,8 +208,23 @@ func (p *Backend) UnmarshalBinary(data []byte) error {
 
 	p.id = inner.URL
 	p.name = inner.Name
+	p.target = nil
+	if inner.Target != "" {
+		target, err := url.Parse(inner.Target)
+		if err != nil {
+			return fmt.Errorf("parsing target of backend '%s': %w", inner.URL, err)
+		}
+		p.target = target
+	}
 	p.count = new(atomic.Int64)
 	p.count.Store(inner.Count)
+	p.weight = inner.Weight
+	p.manualWeight = inner.ManualWeight
+	p.maxRequests = inner.MaxRequests
+	p.unhealthy = inner.Unhealthy
+	p.cordoned = inner.Cordoned
+	p.ejectedUntil = inner.EjectedUntil
+	p.drainingSince = inner.DrainingSince
 
 	return nil
 }
@@ -11,15 +11,82 @@ type Config struct {
 	Namespace     string `mapstructure:"SPEC_NAMESPACE"`
 	ServiceName   string `mapstructure:"SPEC_SERVICE_NAME"`
 	Selector      string `mapstructure:"SPEC_SELECTOR"`
+	// PortName is the named container port of the pods, it takes precedence over SPEC_CONTAINER_PORT
+	PortName string `mapstructure:"SPEC_PORT_NAME"`
+
+	// TargetScheme and TargetDNS shape the url requests are sent to,
+	// the dns name of the pod is needed when TLS checks the server name
+	TargetScheme string `mapstructure:"TARGET_SCHEME"`
+	TargetDNS    bool   `mapstructure:"TARGET_DNS"`
+
+	WeightAnnotation      string `mapstructure:"WEIGHT_ANNOTATION"`
+	MaxRequestsAnnotation string `mapstructure:"MAX_REQUESTS_ANNOTATION"`
+	MaxRequestsPerBackend int    `mapstructure:"MAX_REQUESTS_PER_BACKEND"`
+
+	QueueSize    int           `mapstructure:"QUEUE_SIZE"`
+	QueueTimeout time.Duration `mapstructure:"QUEUE_TIMEOUT"`
+
+	Strategy     string `mapstructure:"STRATEGY"`
+	HashKey      string `mapstructure:"HASH_KEY"`
+	HashReplicas int    `mapstructure:"HASH_REPLICAS"`
+	P2CChoices   int    `mapstructure:"P2C_CHOICES"`
+
+	AffinityCookieName string        `mapstructure:"AFFINITY_COOKIE_NAME"`
+	AffinityCookieTTL  time.Duration `mapstructure:"AFFINITY_COOKIE_TTL"`
+	AffinityCookieKey  string        `mapstructure:"AFFINITY_COOKIE_KEY"`
 
 	StoreType     string `mapstructure:"STORE_TYPE"`
 	StoreAddr     string `mapstructure:"STORE_ADDR"`
 	StoreUsername string `mapstructure:"STORE_USERNAME"`
 	StorePassword string `mapstructure:"STORE_PASSWORD"`
 	StoreDB       int    `mapstructure:"STORE_DB"`
+	// StoreNamespace prefixes the keys of the store, routes default to their name
+	StoreNamespace string `mapstructure:"STORE_NAMESPACE"`
+
+	RoutesFile string `mapstructure:"ROUTES_FILE"`
+	// Transform is set from the route table
+	Transform Transform `mapstructure:"-"`
 
 	RefreshRate time.Duration `mapstructure:"REFRESH_RATE"`
 	LockTTL     time.Duration `mapstructure:"LOCK_TTL"`
+	// CounterLeaseTTL is how long the contributions of an instance outlive it
+	CounterLeaseTTL time.Duration `mapstructure:"COUNTER_LEASE_TTL"`
+
+	DrainTimeout time.Duration `mapstructure:"DRAIN_TIMEOUT"`
+
+	ErrorFormat string `mapstructure:"ERROR_FORMAT"`
+
+	RetryAttempts      int           `mapstructure:"RETRY_ATTEMPTS"`
+	RetryPerTryTimeout time.Duration `mapstructure:"RETRY_PER_TRY_TIMEOUT"`
+	RetryBudget        float64       `mapstructure:"RETRY_BUDGET"`
+	RetryMaxBodySize   int64         `mapstructure:"RETRY_MAX_BODY_SIZE"`
+
+	HedgePaths []string      `mapstructure:"HEDGE_PATHS"`
+	HedgeDelay time.Duration `mapstructure:"HEDGE_DELAY"`
+
+	AdminToken string `mapstructure:"ADMIN_TOKEN"`
+
+	AccessLogFormat     string  `mapstructure:"ACCESS_LOG_FORMAT"`
+	AccessLogOutput     string  `mapstructure:"ACCESS_LOG_OUTPUT"`
+	AccessLogSampleRate float64 `mapstructure:"ACCESS_LOG_SAMPLE_RATE"`
+
+	TracingEndpoint    string  `mapstructure:"TRACING_ENDPOINT"`
+	TracingInsecure    bool    `mapstructure:"TRACING_INSECURE"`
+	TracingSampleRatio float64 `mapstructure:"TRACING_SAMPLE_RATIO"`
+
+	HealthCheckPath               string        `mapstructure:"HEALTH_CHECK_PATH"`
+	HealthCheckInterval           time.Duration `mapstructure:"HEALTH_CHECK_INTERVAL"`
+	HealthCheckTimeout            time.Duration `mapstructure:"HEALTH_CHECK_TIMEOUT"`
+	HealthCheckHealthyThreshold   int           `mapstructure:"HEALTH_CHECK_HEALTHY_THRESHOLD"`
+	HealthCheckUnhealthyThreshold int           `mapstructure:"HEALTH_CHECK_UNHEALTHY_THRESHOLD"`
+
+	OutlierConsecutiveFailures int           `mapstructure:"OUTLIER_CONSECUTIVE_FAILURES"`
+	OutlierErrorRate           float64       `mapstructure:"OUTLIER_ERROR_RATE"`
+	OutlierMinRequests         int           `mapstructure:"OUTLIER_MIN_REQUESTS"`
+	OutlierInterval            time.Duration `mapstructure:"OUTLIER_INTERVAL"`
+	OutlierBaseEjectionTime    time.Duration `mapstructure:"OUTLIER_BASE_EJECTION_TIME"`
+	OutlierMaxEjectionTime     time.Duration `mapstructure:"OUTLIER_MAX_EJECTION_TIME"`
+	OutlierMaxEjectionPercent  int           `mapstructure:"OUTLIER_MAX_EJECTION_PERCENT"`
 }
 
 func Parse(path string) (*Config, error) {
@@ -37,6 +104,9 @@ func Parse(path string) (*Config, error) {
 
 	viper.AutomaticEnv()
 
+	// keys whose zero value would turn a feature half off when they are left out
+	viper.SetDefault("ACCESS_LOG_SAMPLE_RATE", 1)
+
 	if err := viper.ReadInConfig(); err != nil {
 		return nil, fmt.Errorf("reading in config: %w", err)
 	}
@@ -1,8 +1,11 @@
 package config
 
 import (
-	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestParse(t *testing.T) {
@@ -10,3 +13,12 @@ func TestParse(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 8080, cfg.ContainerPort)
 }
+
+func TestParseDefaults(t *testing.T) {
+	file := filepath.Join(t.TempDir(), ".env")
+	assert.NoError(t, os.WriteFile(file, []byte("ACCESS_LOG_FORMAT=json\n"), 0o600))
+
+	cfg, err := Parse(file)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, cfg.AccessLogSampleRate)
+}
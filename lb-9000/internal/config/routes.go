@@ -0,0 +1,138 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/spf13/viper"
+)
+
+// Match selects the requests of a route, empty fields match every request
+type Match struct {
+	Host       string            `mapstructure:"host"`
+	PathPrefix string            `mapstructure:"pathPrefix"`
+	Method     string            `mapstructure:"method"`
+	Headers    map[string]string `mapstructure:"headers"`
+}
+
+// Transform rewrites the requests and responses of a route
+type Transform struct {
+	// StripPrefix is removed from the path, ReplacePrefix takes its place
+	StripPrefix   string          `mapstructure:"stripPrefix"`
+	ReplacePrefix string          `mapstructure:"replacePrefix"`
+	Request       HeaderTransform `mapstructure:"request"`
+	Response      HeaderTransform `mapstructure:"response"`
+}
+
+// HeaderTransform is applied in the order remove, set, add
+type HeaderTransform struct {
+	Add    map[string]string `mapstructure:"add"`
+	Set    map[string]string `mapstructure:"set"`
+	Remove []string          `mapstructure:"remove"`
+}
+
+// Route is a rule of the route table with the configuration of its pool
+type Route struct {
+	Name   string
+	Match  Match
+	Config *Config
+}
+
+// processKeys configure the whole process, a route cannot override them
+var processKeys = []string{
+	"ROUTES_FILE",
+	"LOCK_TTL",
+	"ADMIN_TOKEN",
+	"ACCESS_LOG_FORMAT",
+	"ACCESS_LOG_OUTPUT",
+	"ACCESS_LOG_SAMPLE_RATE",
+	"TRACING_ENDPOINT",
+	"TRACING_INSECURE",
+	"TRACING_SAMPLE_RATIO",
+}
+
+// HasPathPrefix reports whether path starts with the path segments of prefix,
+// "/api" matches "/api" and "/api/jobs" but not "/apis"
+func HasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+type routesFile struct {
+	Routes []struct {
+		Name      string         `mapstructure:"name"`
+		Match     Match          `mapstructure:"match"`
+		Transform Transform      `mapstructure:"transform"`
+		Config    map[string]any `mapstructure:"config"`
+	} `mapstructure:"routes"`
+}
+
+// ParseRoutes reads the route table of ROUTES_FILE. Every route starts from a copy of cfg
+// and overrides keys with its own config section. Without a file there is a single route for all requests
+func ParseRoutes(cfg *Config) ([]Route, error) {
+	if cfg.RoutesFile == "" {
+		return []Route{{Name: "default", Config: cfg}}, nil
+	}
+
+	reader := viper.New()
+	reader.SetConfigFile(cfg.RoutesFile)
+
+	if err := reader.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("reading routes: %w", err)
+	}
+
+	var file routesFile
+	if err := reader.Unmarshal(&file); err != nil {
+		return nil, fmt.Errorf("unmarshaling routes: %w", err)
+	}
+
+	if len(file.Routes) == 0 {
+		return nil, fmt.Errorf("no routes in '%s'", cfg.RoutesFile)
+	}
+
+	routes := make([]Route, 0, len(file.Routes))
+	names := map[string]bool{}
+
+	for _, raw := range file.Routes {
+		if raw.Name == "" || names[raw.Name] {
+			return nil, fmt.Errorf("routes need a unique name, got '%s'", raw.Name)
+		}
+		names[raw.Name] = true
+
+		for key := range raw.Config {
+			if slices.Contains(processKeys, strings.ToUpper(key)) {
+				return nil, fmt.Errorf("config of route '%s': '%s' applies to the whole process", raw.Name, key)
+			}
+		}
+
+		routeConfig := *cfg
+		routeConfig.StoreNamespace = raw.Name
+		routeConfig.Transform = raw.Transform
+
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			Result:           &routeConfig,
+			WeaklyTypedInput: true,
+			ErrorUnused:      true,
+			DecodeHook: mapstructure.ComposeDecodeHookFunc(
+				mapstructure.StringToTimeDurationHookFunc(),
+				mapstructure.StringToSliceHookFunc(","),
+			),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating decoder: %w", err)
+		}
+
+		if err = decoder.Decode(raw.Config); err != nil {
+			return nil, fmt.Errorf("config of route '%s': %w", raw.Name, err)
+		}
+
+		routes = append(routes, Route{Name: raw.Name, Match: raw.Match, Config: &routeConfig})
+	}
+
+	return routes, nil
+}
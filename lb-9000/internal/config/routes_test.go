@@ -0,0 +1,56 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRoutes(t *testing.T) {
+	cfg := &Config{Selector: "app=server", QueueTimeout: time.Second}
+
+	routes, err := ParseRoutes(cfg)
+	assert.NoError(t, err)
+	assert.Len(t, routes, 1)
+	assert.Same(t, cfg, routes[0].Config)
+
+	cfg.RoutesFile = "routes.yaml"
+	routes, err = ParseRoutes(cfg)
+	assert.NoError(t, err)
+	assert.Len(t, routes, 2)
+
+	assert.Equal(t, "gpu", routes[0].Name)
+	assert.Equal(t, "/gpu", routes[0].Match.PathPrefix)
+	assert.Equal(t, "app=gpu-worker", routes[0].Config.Selector)
+	assert.Equal(t, "p2c", routes[0].Config.Strategy)
+	assert.Equal(t, "gpu", routes[0].Config.StoreNamespace)
+	assert.Equal(t, time.Second, routes[0].Config.QueueTimeout)
+	assert.Equal(t, "/gpu", routes[0].Config.Transform.StripPrefix)
+	assert.Equal(t, []string{"Server"}, routes[0].Config.Transform.Response.Remove)
+	assert.Len(t, routes[0].Config.Transform.Request.Set, 1)
+
+	assert.Equal(t, "app=server", routes[1].Config.Selector)
+	assert.Equal(t, "default", routes[1].Config.StoreNamespace)
+	assert.Equal(t, "app=server", cfg.Selector)
+}
+
+func TestParseRoutesWithProcessKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "routes.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte("routes:\n  - name: api\n    config:\n      admin_token: secret\n"), 0o600))
+
+	_, err := ParseRoutes(&Config{RoutesFile: file})
+	assert.ErrorContains(t, err, "applies to the whole process")
+}
+
+func TestHasPathPrefix(t *testing.T) {
+	assert.True(t, HasPathPrefix("/api", "/api"))
+	assert.True(t, HasPathPrefix("/api/jobs", "/api"))
+	assert.True(t, HasPathPrefix("/api/jobs", "/api/"))
+	assert.True(t, HasPathPrefix("/anything", ""))
+	assert.True(t, HasPathPrefix("/anything", "/"))
+	assert.False(t, HasPathPrefix("/apis", "/api"))
+	assert.False(t, HasPathPrefix("/status", "/api"))
+}
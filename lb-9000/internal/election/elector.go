@@ -2,6 +2,7 @@ package election
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -52,6 +53,20 @@ func (e *Elector) IsLeader() bool {
 	return e.status.Load() == uint32(StatusLeader)
 }
 
+// Leader returns the instance id of the current leader, it is empty while there is none
+func (e *Elector) Leader(ctx context.Context) (string, error) {
+	leader, err := e.redis.Get(ctx, leaderKey).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("getting leader: %w", err)
+	}
+
+	return leader, nil
+}
+
 func (e *Elector) Loop() {
 	stopCh := make(chan os.Signal, 1)
 	signal.Notify(stopCh, syscall.SIGINT, syscall.SIGTERM)
@@ -0,0 +1,165 @@
+package health
+
+import (
+	"context"
+	"io"
+	"lb-9000/lb-9000/internal/backend"
+	"lb-9000/lb-9000/internal/config"
+	"lb-9000/lb-9000/internal/election"
+	"lb-9000/lb-9000/internal/orchestration"
+	"lb-9000/lb-9000/internal/store"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Checker actively probes the backends and marks them (un)healthy in the store.
+// Only the leader probes, the other replicas pick the state up from the store.
+type Checker struct {
+	store         store.Store
+	orchestration orchestration.Orchestration
+	elector       *election.Elector
+	client        *http.Client
+	logger        *slog.Logger
+
+	path               string
+	interval           time.Duration
+	timeout            time.Duration
+	healthyThreshold   int
+	unhealthyThreshold int
+
+	// streaks holds the consecutive probe results per backend,
+	// positive numbers are successes and negative numbers failures
+	streaks map[string]int
+}
+
+func NewChecker(
+	store store.Store,
+	orchestration orchestration.Orchestration,
+	elector *election.Elector,
+	logger *slog.Logger,
+	cfg *config.Config,
+) *Checker {
+	return &Checker{
+		store:              store,
+		orchestration:      orchestration,
+		elector:            elector,
+		client:             &http.Client{},
+		logger:             logger,
+		path:               cfg.HealthCheckPath,
+		interval:           cfg.HealthCheckInterval,
+		timeout:            cfg.HealthCheckTimeout,
+		healthyThreshold:   max(1, cfg.HealthCheckHealthyThreshold),
+		unhealthyThreshold: max(1, cfg.HealthCheckUnhealthyThreshold),
+		streaks:            make(map[string]int),
+	}
+}
+
+func (c *Checker) Loop() {
+	if c.path == "" || c.interval <= 0 {
+		c.logger.Info("health checks disabled")
+		return
+	}
+
+	for range time.Tick(c.interval) {
+		if !c.elector.IsLeader() {
+			continue
+		}
+
+		c.checkAll(context.Background())
+	}
+}
+
+func (c *Checker) checkAll(ctx context.Context) {
+	backends, err := c.store.All(ctx)
+	if err != nil {
+		c.logger.Error("cannot get backends for health checks", "error", err)
+		return
+	}
+
+	results := make([]bool, len(backends))
+
+	var wg sync.WaitGroup
+	for i, b := range backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.probe(ctx, b)
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool, len(backends))
+	for i, b := range backends {
+		seen[b.URL()] = true
+		c.record(ctx, b, results[i])
+	}
+
+	// forget about backends that have left the pool
+	for id := range c.streaks {
+		if !seen[id] {
+			delete(c.streaks, id)
+		}
+	}
+}
+
+func (c *Checker) probe(ctx context.Context, b *backend.Backend) bool {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.path, nil)
+	if err != nil {
+		c.logger.Error("cannot create health check request", "error", err)
+		return false
+	}
+
+	c.orchestration.DirectRequest(request, b)
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		c.logger.Debug("health check failed", "url", b.URL(), "error", err)
+		return false
+	}
+
+	defer func() {
+		_, _ = io.Copy(io.Discard, response.Body)
+		_ = response.Body.Close()
+	}()
+
+	return response.StatusCode >= http.StatusOK && response.StatusCode < http.StatusBadRequest
+}
+
+func (c *Checker) record(ctx context.Context, b *backend.Backend, ok bool) {
+	id := b.URL()
+	streak := c.streaks[id]
+
+	if ok {
+		streak = max(0, streak) + 1
+	} else {
+		streak = min(0, streak) - 1
+	}
+
+	c.streaks[id] = streak
+
+	switch {
+	case !b.Healthy() && streak >= c.healthyThreshold:
+		c.setHealthy(ctx, id, true)
+	case b.Healthy() && -streak >= c.unhealthyThreshold:
+		c.setHealthy(ctx, id, false)
+	}
+}
+
+func (c *Checker) setHealthy(ctx context.Context, id string, healthy bool) {
+	if err := c.store.Update(ctx, id, func(b *backend.Backend) {
+		b.SetHealthy(healthy)
+	}); err != nil {
+		c.logger.Error("cannot update backend health", "url", id, "error", err)
+		return
+	}
+
+	c.logger.Info("backend health changed", "url", id, "healthy", healthy)
+}
@@ -0,0 +1,87 @@
+package health
+
+import (
+	"context"
+	"lb-9000/lb-9000/internal/backend"
+	"lb-9000/lb-9000/internal/config"
+	"lb-9000/lb-9000/internal/store"
+	"lb-9000/lb-9000/internal/store/memory"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type directOrchestration struct{}
+
+func (directOrchestration) StartObserver(context.Context, store.Store) {}
+
+func (directOrchestration) DirectRequest(request *http.Request, backend *backend.Backend) {
+	request.URL.Scheme = "http"
+	request.URL.Host = backend.URL()
+}
+
+func (directOrchestration) InstanceID() string {
+	return "test"
+}
+
+func TestCheckAll(t *testing.T) {
+	failing := &atomic.Bool{}
+	failing.Store(true)
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer flaky.Close()
+
+	ctx := context.Background()
+	backendStore := memory.New(slog.Default())
+
+	healthyID := strings.TrimPrefix(healthy.URL, "http://")
+	flakyID := strings.TrimPrefix(flaky.URL, "http://")
+
+	assert.NoError(t, backendStore.Add(ctx, backend.NewBackend(healthyID, "healthy")))
+	assert.NoError(t, backendStore.Add(ctx, backend.NewBackend(flakyID, "flaky")))
+
+	checker := NewChecker(backendStore, directOrchestration{}, nil, slog.Default(), &config.Config{
+		HealthCheckPath:               "/health",
+		HealthCheckHealthyThreshold:   2,
+		HealthCheckUnhealthyThreshold: 3,
+	})
+
+	healthOf := func() map[string]bool {
+		backends, err := backendStore.All(ctx)
+		assert.NoError(t, err)
+
+		result := make(map[string]bool)
+		for _, b := range backends {
+			result[b.URL()] = b.Healthy()
+		}
+
+		return result
+	}
+
+	checker.checkAll(ctx)
+	checker.checkAll(ctx)
+	assert.Equal(t, map[string]bool{healthyID: true, flakyID: true}, healthOf())
+
+	checker.checkAll(ctx)
+	assert.Equal(t, map[string]bool{healthyID: true, flakyID: false}, healthOf())
+
+	failing.Store(false)
+
+	checker.checkAll(ctx)
+	assert.Equal(t, map[string]bool{healthyID: true, flakyID: false}, healthOf())
+
+	checker.checkAll(ctx)
+	assert.Equal(t, map[string]bool{healthyID: true, flakyID: true}, healthOf())
+}
@@ -0,0 +1,37 @@
+package httperror
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"lb-9000/lb-9000/internal/metrics"
+	"net/http"
+	"strconv"
+)
+
+// Write answers with an error written by the load balancer itself, format is json (default), html or text
+func Write(writer http.ResponseWriter, format string, status int, message string) {
+	metrics.ErrorResponses.WithLabelValues(strconv.Itoa(status)).Inc()
+
+	switch format {
+	case "html":
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		writer.WriteHeader(status)
+		_, _ = fmt.Fprintf(
+			writer,
+			"<!DOCTYPE html>\n<html><head><title>%[1]d %[2]s</title></head><body><h1>%[1]d %[2]s</h1><p>%[3]s</p></body></html>\n",
+			status,
+			http.StatusText(status),
+			html.EscapeString(message),
+		)
+	case "text":
+		http.Error(writer, message, status)
+	default:
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(status)
+		_ = json.NewEncoder(writer).Encode(map[string]any{
+			"status": status,
+			"error":  message,
+		})
+	}
+}
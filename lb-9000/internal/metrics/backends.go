@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"context"
+	"lb-9000/lb-9000/internal/store"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const collectTimeout = 5 * time.Second
+
+// backendCollector reads the backends of a route from its store on every scrape
+type backendCollector struct {
+	store        store.Store
+	logger       *slog.Logger
+	inFlightDesc *prometheus.Desc
+	stateDesc    *prometheus.Desc
+}
+
+func RegisterBackends(route string, store store.Store, logger *slog.Logger) {
+	labels := prometheus.Labels{"route": route}
+
+	prometheus.MustRegister(&backendCollector{
+		store:  store,
+		logger: logger,
+		inFlightDesc: prometheus.NewDesc(
+			namespace+"_backend_in_flight_requests",
+			"Requests in flight per backend, as seen by the store.",
+			[]string{"backend"},
+			labels,
+		),
+		stateDesc: prometheus.NewDesc(
+			namespace+"_backend_state",
+			"State of the backend, 1 for the states it is in.",
+			[]string{"backend", "state"},
+			labels,
+		),
+	})
+}
+
+func (c *backendCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- c.inFlightDesc
+	descs <- c.stateDesc
+}
+
+func (c *backendCollector) Collect(metrics chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+
+	backends, err := c.store.All(ctx)
+	if err != nil {
+		c.logger.Error("cannot collect backend metrics", "error", err)
+		return
+	}
+
+	for _, b := range backends {
+		metrics <- prometheus.MustNewConstMetric(c.inFlightDesc, prometheus.GaugeValue, float64(b.Count()), b.URL())
+
+		for state, value := range map[string]bool{
+			"healthy":  b.Healthy(),
+			"ejected":  b.Ejected(),
+			"draining": b.Draining(),
+			"cordoned": b.Cordoned(),
+		} {
+			metrics <- prometheus.MustNewConstMetric(c.stateDesc, prometheus.GaugeValue, Bool(value), b.URL(), state)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "lb9000"
+
+// requestBuckets go up to ten minutes, requests to the backends can run for that long
+var requestBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+var (
+	Requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Proxied requests by backend and status code.",
+	}, []string{"backend", "code"})
+
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Time until the response headers of the backend arrived, by backend and status code.",
+		Buckets:   requestBuckets,
+	}, []string{"backend", "code"})
+
+	ErrorResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "error_responses_total",
+		Help:      "Error responses written by the load balancer itself, by status code.",
+	}, []string{"code"})
+
+	StoreOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "store_operation_duration_seconds",
+		Help:      "Latency of the store operations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	StoreErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "store_errors_total",
+		Help:      "Failed store operations.",
+	}, []string{"operation"})
+
+	Retries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retries_total",
+		Help:      "Retries of failed attempts, by outcome.",
+	}, []string{"outcome"})
+
+	Hedges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "hedges_total",
+		Help:      "Hedged attempts, by outcome.",
+	}, []string{"outcome"})
+
+	WatchEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "watch_events_total",
+		Help:      "Events received by the orchestrator watcher, by event type.",
+	}, []string{"type"})
+)
+
+// RegisterGauge exposes a value that is read on every scrape, the labels tell apart gauges of the same name
+func RegisterGauge(name, help string, labels map[string]string, value func() float64) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        name,
+		Help:        help,
+		ConstLabels: labels,
+	}, value)
+}
+
+func Bool(value bool) float64 {
+	if value {
+		return 1
+	}
+
+	return 0
+}
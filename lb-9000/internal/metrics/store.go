@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"context"
+	"iter"
+	"lb-9000/lb-9000/internal/backend"
+	"lb-9000/lb-9000/internal/store"
+	"time"
+)
+
+// InstrumentStore records the latency and the errors of every operation of the store
+func InstrumentStore(inner store.Store) store.Store {
+	return &instrumentedStore{inner: inner}
+}
+
+type instrumentedStore struct {
+	inner store.Store
+}
+
+// observe takes a pointer to the error, so it can be deferred before the operation ran
+func observe(operation string, start time.Time, err *error) {
+	StoreOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+
+	if *err != nil {
+		StoreErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+func (s *instrumentedStore) Add(ctx context.Context, backend *backend.Backend) (err error) {
+	defer observe("add", time.Now(), &err)
+	return s.inner.Add(ctx, backend)
+}
+
+func (s *instrumentedStore) Remove(ctx context.Context, id string) (err error) {
+	defer observe("remove", time.Now(), &err)
+	return s.inner.Remove(ctx, id)
+}
+
+func (s *instrumentedStore) Get(ctx context.Context, id string) (_ *backend.Backend, err error) {
+	defer observe("get", time.Now(), &err)
+	return s.inner.Get(ctx, id)
+}
+
+func (s *instrumentedStore) AddRequests(ctx context.Context, id string, n int64) (err error) {
+	defer observe("add_requests", time.Now(), &err)
+	return s.inner.AddRequests(ctx, id, n)
+}
+
+func (s *instrumentedStore) Update(ctx context.Context, id string, fn func(backend *backend.Backend)) (err error) {
+	defer observe("update", time.Now(), &err)
+	return s.inner.Update(ctx, id, fn)
+}
+
+func (s *instrumentedStore) Iterate(ctx context.Context) (_ iter.Seq[*backend.Backend], err error) {
+	defer observe("iterate", time.Now(), &err)
+	return s.inner.Iterate(ctx)
+}
+
+func (s *instrumentedStore) All(ctx context.Context) (_ []*backend.Backend, err error) {
+	defer observe("all", time.Now(), &err)
+	return s.inner.All(ctx)
+}
+
+func (s *instrumentedStore) Sample(ctx context.Context, n int) (_ []*backend.Backend, err error) {
+	defer observe("sample", time.Now(), &err)
+	return s.inner.Sample(ctx, n)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
 	k8s "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"lb-9000/lb-9000/internal/config"
@@ -27,21 +28,35 @@ func NewKubernetes(
 		return nil, fmt.Errorf("creating client set: %w", err)
 	}
 
-	watcher, err := clientSet.
-		CoreV1().
-		Pods(config.Namespace).
-		Watch(context.Background(), metav1.ListOptions{
-			LabelSelector: config.Selector,
-			FieldSelector: "status.phase=" + string(core.PodRunning),
-		})
+	options := metav1.ListOptions{
+		LabelSelector: config.Selector,
+		FieldSelector: "status.phase=" + string(core.PodRunning),
+	}
+
+	watchPods := func(ctx context.Context) (watch.Interface, error) {
+		return clientSet.CoreV1().Pods(config.Namespace).Watch(ctx, options)
+	}
+
+	listPods := func(ctx context.Context) ([]core.Pod, error) {
+		pods, err := clientSet.CoreV1().Pods(config.Namespace).List(ctx, options)
+		if err != nil {
+			return nil, err
+		}
+
+		return pods.Items, nil
+	}
 
+	// the first watch fails the startup, later ones are retried by the observer
+	watcher, err := watchPods(context.Background())
 	if err != nil {
 		return nil, fmt.Errorf("error watching pods: %w", err)
 	}
 
 	return &kubernetes{
 		logger:  logger,
+		watch:   watchPods,
 		watcher: watcher,
+		list:    listPods,
 		config:  config,
 	}, nil
 }
@@ -5,78 +5,206 @@ import (
 	"fmt"
 	"lb-9000/lb-9000/internal/backend"
 	"lb-9000/lb-9000/internal/config"
+	"lb-9000/lb-9000/internal/metrics"
 	"lb-9000/lb-9000/internal/store"
 	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/watch"
 )
 
 type kubernetes struct {
-	logger  *slog.Logger
+	logger *slog.Logger
+	// watch starts a new watch of the pods, watcher is the current one
+	watch   func(ctx context.Context) (watch.Interface, error)
 	watcher watch.Interface
-	config  *config.Config
+	// list returns the running pods, it finds the ones deleted while no watch was open
+	list   func(ctx context.Context) ([]core.Pod, error)
+	config *config.Config
 }
 
 func (k *kubernetes) DirectRequest(
 	request *http.Request,
 	backend *backend.Backend,
 ) {
-	request.URL.Scheme = "http"
-	// todo this should be done beforehand
-	request.URL.Host = fmt.Sprintf(
-		"%s.%s.%s.svc.cluster.local:%d",
-		strings.ReplaceAll(backend.URL(), ".", "-"),
-		k.config.ServiceName,
-		k.config.Namespace,
-		k.config.ContainerPort,
-	)
+	target := backend.Target()
+	if target == nil {
+		// records stored before the targets were
+		target = k.target(backend.URL(), k.config.ContainerPort)
+	}
+
+	request.URL.Scheme = target.Scheme
+	request.URL.Host = target.Host
 }
 
-func (k *kubernetes) GetBackendIDFromResponse(response *http.Response) (string, error) {
-	ip, err := getIpFromHost(response.Request.URL.Host)
-	if err != nil {
-		return "", fmt.Errorf("error getting ip from host: %w", err)
+// target builds the url the requests of the pod are sent to, once when the pod is added
+func (k *kubernetes) target(ip string, port int) *url.URL {
+	scheme := k.config.TargetScheme
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	host := ip
+	if k.config.TargetDNS {
+		host = fmt.Sprintf("%s.%s.%s.svc.cluster.local", dnsLabel(ip), k.config.ServiceName, k.config.Namespace)
 	}
 
-	return ip, nil
+	return &url.URL{Scheme: scheme, Host: net.JoinHostPort(host, strconv.Itoa(port))}
 }
 
-func (k *kubernetes) StartObserver(store store.Store) {
-	ctx := context.Background()
+// port returns the container port named by SPEC_PORT_NAME, or SPEC_CONTAINER_PORT without a name
+func (k *kubernetes) port(pod *core.Pod) (int, error) {
+	if k.config.PortName == "" {
+		return k.config.ContainerPort, nil
+	}
 
-	for event := range k.watcher.ResultChan() {
-		podFromEvent, ok := event.Object.(*core.Pod)
-		if !ok {
-			if k.logger != nil {
-				k.logger.Error("unexpected object type", "object", event.Object)
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Name == k.config.PortName {
+				return int(port.ContainerPort), nil
 			}
+		}
+	}
+
+	return 0, fmt.Errorf("pod '%s' has no port named '%s'", pod.Name, k.config.PortName)
+}
+
+func (k *kubernetes) StartObserver(ctx context.Context, store store.Store) {
+	for {
+		if k.watcher == nil {
+			watcher, err := k.watch(ctx)
+			if err != nil {
+				if k.logger != nil {
+					k.logger.Error("error watching pods", "error", err)
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Second):
+					continue
+				}
+			}
+
+			k.watcher = watcher
+
+			// pods deleted while no watch was open never send a deleted event
+			k.resync(ctx, store)
+		}
+
+		k.observe(ctx, store)
+
+		// a new watch sends every running pod again, resync drains the ones that are gone
+		k.watcher.Stop()
+		k.watcher = nil
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if k.logger != nil {
+			k.logger.Info("pod watch closed, watching again")
+		}
+	}
+}
+
+// resync drains the backends whose pod is not running anymore, the watch is open already,
+// so pods deleted after the list still send their event
+func (k *kubernetes) resync(ctx context.Context, store store.Store) {
+	if k.list == nil {
+		return
+	}
+
+	pods, err := k.list(ctx)
+	if err != nil {
+		if k.logger != nil {
+			k.logger.Error("error listing pods", "error", err)
+		}
+		return
+	}
+
+	running := make(map[string]string, len(pods))
+	for _, pod := range pods {
+		running[pod.Status.PodIP] = pod.Name
+	}
+
+	backends, err := store.All(ctx)
+	if err != nil {
+		if k.logger != nil {
+			k.logger.Error("error getting backends to resync", "error", err)
+		}
+		return
+	}
+
+	for _, b := range backends {
+		if name, ok := running[b.URL()]; ok && name == b.Name() || b.Draining() {
 			continue
 		}
 
-		switch event.Type {
-		case watch.Added:
-			// when a pod is added, it needs to be added to the pool
-			// at this time the pod may not have a URL assigned yet
-			k.addBackend(ctx, store, podFromEvent)
-		case watch.Deleted:
-			// when a pod is deleted, it needs to be removed from the pool
-			k.removeBackend(ctx, store, podFromEvent)
-		case watch.Modified:
-			// there are several cases when a pod is modified:
-			// 1. the pod is being deleted -> it will have a deletion timestamp
-			// 2. the pod changed state and is now running -> it will have an URL
-			if podFromEvent.DeletionTimestamp != nil {
-				k.removeBackend(ctx, store, podFromEvent)
-			} else if podFromEvent.Status.PodIP != "" {
-				// todo look at the state here maybe?
-				k.addBackend(ctx, store, podFromEvent)
+		k.drainBackend(ctx, store, &core.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: b.Name()},
+			Status:     core.PodStatus{PodIP: b.URL()},
+		})
+	}
+}
+
+// observe handles the events of the watch until it is closed or ctx is done
+func (k *kubernetes) observe(ctx context.Context, store store.Store) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-k.watcher.ResultChan():
+			if !ok {
+				return
 			}
+
+			k.handle(ctx, store, event)
+		}
+	}
+}
+
+func (k *kubernetes) handle(ctx context.Context, store store.Store, event watch.Event) {
+	podFromEvent, ok := event.Object.(*core.Pod)
+	if !ok {
+		if k.logger != nil {
+			k.logger.Error("unexpected object type", "object", event.Object)
+		}
+		return
+	}
+
+	metrics.WatchEvents.WithLabelValues(string(event.Type)).Inc()
+
+	switch event.Type {
+	case watch.Added:
+		// when a pod is added, it needs to be added to the pool
+		// at this time the pod may not have a URL assigned yet
+		if podFromEvent.DeletionTimestamp != nil {
+			k.drainBackend(ctx, store, podFromEvent)
+		} else {
+			k.addBackend(ctx, store, podFromEvent)
+		}
+	case watch.Deleted:
+		// when a pod is deleted, it needs to be removed from the pool
+		// once its in-flight requests are done, the pool takes care of that
+		k.drainBackend(ctx, store, podFromEvent)
+	case watch.Modified:
+		// there are several cases when a pod is modified:
+		// 1. the pod is being deleted -> it will have a deletion timestamp
+		// 2. the pod changed state and is now running -> it will have an URL
+		if podFromEvent.DeletionTimestamp != nil {
+			k.drainBackend(ctx, store, podFromEvent)
+		} else if podFromEvent.Status.PodIP != "" {
+			// todo look at the state here maybe?
+			k.addBackend(ctx, store, podFromEvent)
 		}
 	}
 }
@@ -85,14 +213,25 @@ func (k *kubernetes) InstanceID() string {
 	return os.Getenv("HOSTNAME")
 }
 
-func (k *kubernetes) removeBackend(
+func (k *kubernetes) drainBackend(
 	ctx context.Context,
 	store store.Store,
 	pod *core.Pod,
 ) {
-	if err := store.Remove(ctx, pod.Status.PodIP); err != nil {
+	if pod.Status.PodIP == "" {
+		// the pod never made it into the pool
+		return
+	}
+
+	if err := store.Update(ctx, pod.Status.PodIP, func(b *backend.Backend) {
+		// a late event of a deleted pod must not drain a new pod that got its ip
+		if b.Name() == pod.Name {
+			b.Drain(time.Now())
+		}
+	}); err != nil {
+		// a terminating pod emits several events, it may have been drained and removed already
 		if k.logger != nil {
-			k.logger.Error("error removing backend", "error", err)
+			k.logger.DebugContext(ctx, "error draining backend", "error", err)
 		}
 	}
 }
@@ -102,28 +241,55 @@ func (k *kubernetes) addBackend(
 	store store.Store,
 	pod *core.Pod,
 ) {
-	if err := store.Add(ctx, backend.NewBackend(pod.Status.PodIP, pod.Name)); err != nil {
+	port, err := k.port(pod)
+	if err != nil {
+		if k.logger != nil {
+			k.logger.Error("error adding backend", "error", err)
+		}
+		return
+	}
+
+	instance := backend.NewBackend(pod.Status.PodIP, pod.Name)
+	instance.SetTarget(k.target(pod.Status.PodIP, port))
+	instance.SetWeight(k.intAnnotation(pod, k.config.WeightAnnotation, 1))
+	instance.SetMaxRequests(k.intAnnotation(pod, k.config.MaxRequestsAnnotation, k.config.MaxRequestsPerBackend))
+
+	if err := store.Add(ctx, instance); err != nil {
 		if k.logger != nil {
 			k.logger.Error("error adding backend", "error", err)
 		}
 	}
 }
 
-func getIpFromHost(host string) (string, error) {
-	if parsedUrl, err := url.ParseRequestURI(host); err == nil {
-		host = parsedUrl.Host
+// intAnnotation reads a positive number from the pod annotation, fallback is used when it is missing or invalid
+func (k *kubernetes) intAnnotation(pod *core.Pod, annotation string, fallback int) int {
+	raw, ok := pod.Annotations[annotation]
+	if annotation == "" || !ok {
+		return fallback
 	}
 
-	ip, _, ok := strings.Cut(host, ".")
-	if !ok {
-		return "", fmt.Errorf("expected to be able to cut host")
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 1 {
+		if k.logger != nil {
+			k.logger.Warn("invalid annotation", "pod", pod.Name, "annotation", annotation, "value", raw, "fallback", fallback)
+		}
+		return fallback
 	}
 
-	ip = strings.ReplaceAll(ip, "-", ".")
-	parsedIp := net.ParseIP(ip)
-	if parsedIp == nil {
-		return "", fmt.Errorf("could not parse ip '%s'", ip)
+	return value
+}
+
+// dnsLabel turns the pod ip into the label of its dns name, the separators of ipv4 and ipv6 addresses become dashes.
+// Labels cannot start or end with a dash, so ipv6 addresses starting or ending with "::" get a zero there like kube-dns does
+func dnsLabel(ip string) string {
+	label := strings.NewReplacer(".", "-", ":", "-").Replace(ip)
+
+	if strings.HasPrefix(label, "-") {
+		label = "0" + label
+	}
+	if strings.HasSuffix(label, "-") {
+		label += "0"
 	}
 
-	return ip, nil
+	return label
 }
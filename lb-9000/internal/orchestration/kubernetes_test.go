@@ -1,21 +1,150 @@
 package orchestration
 
 import (
-	"github.com/stretchr/testify/assert"
+	"context"
+	"lb-9000/lb-9000/internal/backend"
+	"lb-9000/lb-9000/internal/config"
+	"lb-9000/lb-9000/internal/store/memory"
+	"log/slog"
+	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
 )
 
-func TestGetIpFromUrl(t *testing.T) {
-	raw := "http://10-244-0-6.server-service.default.svc.cluster.local:8080/"
+func TestDirectRequest(t *testing.T) {
+	k := &kubernetes{config: &config.Config{
+		ServiceName:   "server-service",
+		Namespace:     "default",
+		ContainerPort: 8080,
+	}}
+
+	// records without a target go to the pod ip
+	request := httptest.NewRequest("GET", "/", nil)
+	k.DirectRequest(request, backend.NewBackend("10.244.0.6", "server"))
+	assert.Equal(t, "http://10.244.0.6:8080/", request.URL.String())
+
+	request = httptest.NewRequest("GET", "/", nil)
+	k.DirectRequest(request, backend.NewBackend("fd00:10:244::6", "server"))
+	assert.Equal(t, "http://[fd00:10:244::6]:8080/", request.URL.String())
+
+	k.config.TargetScheme = "https"
+	k.config.TargetDNS = true
+
+	instance := backend.NewBackend("fd00:10:244::6", "server")
+	instance.SetTarget(k.target(instance.URL(), 8443))
+
+	request = httptest.NewRequest("GET", "/jobs", nil)
+	k.DirectRequest(request, instance)
+	assert.Equal(t, "https://fd00-10-244--6.server-service.default.svc.cluster.local:8443/jobs", request.URL.String())
+}
+
+func TestDNSLabel(t *testing.T) {
+	assert.Equal(t, "10-244-0-6", dnsLabel("10.244.0.6"))
+	assert.Equal(t, "fd00-10-244--6", dnsLabel("fd00:10:244::6"))
+	assert.Equal(t, "0--1", dnsLabel("::1"))
+	assert.Equal(t, "fd00--0", dnsLabel("fd00::"))
+}
+
+func TestAddBackend(t *testing.T) {
+	k := &kubernetes{config: &config.Config{ContainerPort: 8080, PortName: "http"}}
+	backendStore := memory.New(slog.Default())
+
+	pod := func(name string, ports ...core.ContainerPort) *core.Pod {
+		return &core.Pod{
+			ObjectMeta: meta.ObjectMeta{Name: name},
+			Spec:       core.PodSpec{Containers: []core.Container{{Ports: ports}}},
+			Status:     core.PodStatus{PodIP: "10.244.0." + name[len(name)-1:]},
+		}
+	}
+
+	k.addBackend(t.Context(), backendStore, pod("named-1", core.ContainerPort{Name: "metrics", ContainerPort: 9090}, core.ContainerPort{Name: "http", ContainerPort: 9000}))
+	k.addBackend(t.Context(), backendStore, pod("unnamed-2", core.ContainerPort{ContainerPort: 9000}))
+
+	named, err := backendStore.Get(t.Context(), "10.244.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://10.244.0.1:9000", named.Target().String())
+
+	// a pod without the named port cannot be reached
+	unnamed, err := backendStore.Get(t.Context(), "10.244.0.2")
+	assert.NoError(t, err)
+	assert.Nil(t, unnamed)
+}
+
+func TestStartObserver(t *testing.T) {
+	watchers := make(chan *watch.FakeWatcher, 2)
+	k := &kubernetes{
+		config: &config.Config{ContainerPort: 8080},
+		watch: func(context.Context) (watch.Interface, error) {
+			watcher := watch.NewFakeWithChanSize(10, false)
+			watchers <- watcher
+			return watcher, nil
+		},
+		list: func(context.Context) ([]core.Pod, error) {
+			return []core.Pod{{ObjectMeta: meta.ObjectMeta{Name: "new"}, Status: core.PodStatus{PodIP: "10.244.0.6"}}}, nil
+		},
+	}
+
+	backendStore := memory.New(slog.Default())
+
+	// the pod was deleted while no watch was open
+	assert.NoError(t, backendStore.Add(t.Context(), backend.NewBackend("10.244.0.7", "gone")))
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+
+	pod := func(name string, deleted bool) *core.Pod {
+		pod := &core.Pod{
+			ObjectMeta: meta.ObjectMeta{Name: name},
+			Status:     core.PodStatus{PodIP: "10.244.0.6"},
+		}
+		if deleted {
+			pod.DeletionTimestamp = &meta.Time{Time: time.Now()}
+		}
+		return pod
+	}
+
+	first, err := k.watch(ctx)
+	assert.NoError(t, err)
+	k.watcher = first
+
+	go func() {
+		k.StartObserver(ctx, backendStore)
+		close(done)
+	}()
+
+	watcher := <-watchers
+	watcher.Add(pod("old", false))
+	watcher.Modify(pod("old", true))
+
+	// the api server closes the watch, the observer watches again
+	watcher.Stop()
+
+	// a new pod with the ip of the draining one is not draining, not even by a late event of the old one
+	watcher = <-watchers
+	watcher.Add(pod("new", false))
+	watcher.Delete(pod("old", true))
 
-	ip, err := getIpFromHost(raw)
-	assert.Nil(t, err)
-	assert.Equal(t, "10.244.0.6", ip)
+	// events are handled in order, once the marker is in the pool the late event was handled as well
+	watcher.Add(&core.Pod{ObjectMeta: meta.ObjectMeta{Name: "marker"}, Status: core.PodStatus{PodIP: "10.244.0.8"}})
+	assert.Eventually(t, func() bool {
+		b, err := backendStore.Get(t.Context(), "10.244.0.8")
+		assert.NoError(t, err)
+		return b != nil
+	}, time.Second, 10*time.Millisecond)
 
-	raw = "10-244-0-6.server-service.default.svc.cluster.local:8080/"
+	b, err := backendStore.Get(t.Context(), "10.244.0.6")
+	assert.NoError(t, err)
+	assert.Equal(t, "new", b.Name())
+	assert.False(t, b.Draining())
 
-	ip, err = getIpFromHost(raw)
-	assert.Nil(t, err)
+	gone, err := backendStore.Get(t.Context(), "10.244.0.7")
+	assert.NoError(t, err)
+	assert.True(t, gone.Draining())
 
-	assert.Equal(t, "10.244.0.6", ip)
+	cancel()
+	<-done
 }
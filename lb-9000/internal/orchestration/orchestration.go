@@ -1,6 +1,7 @@
 package orchestration
 
 import (
+	"context"
 	"lb-9000/lb-9000/internal/backend"
 	"lb-9000/lb-9000/internal/store"
 	"net/http"
@@ -8,14 +9,12 @@ import (
 
 type Orchestration interface {
 	// StartObserver starts the observer that watches for changes in the orchestrator
-	// it will be run in a separate goroutine
-	StartObserver(store store.Store)
+	// it will be run in a separate goroutine and returns once ctx is done
+	StartObserver(ctx context.Context, store store.Store)
 
-	// DirectRequest directs the request to the correct backend
+	// DirectRequest directs the request to the correct backend,
+	// the pool keeps track of the backend so the request may be rewritten freely
 	DirectRequest(request *http.Request, backend *backend.Backend)
 
-	// GetBackendIDFromResponse gets the name of the backend from the response
-	GetBackendIDFromResponse(response *http.Response) (string, error)
-
 	InstanceID() string
 }
@@ -0,0 +1,221 @@
+package outlier
+
+import (
+	"context"
+	"lb-9000/lb-9000/internal/backend"
+	"lb-9000/lb-9000/internal/config"
+	"lb-9000/lb-9000/internal/store"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// pruneInterval is how often the statistics of backends that left the pool are dropped
+const pruneInterval = time.Minute
+
+// Detector passively watches the outcome of proxied requests and ejects
+// backends that keep failing, similar to envoy's outlier detection.
+// The statistics are local to the replica, the ejections are shared through the store.
+type Detector struct {
+	store  store.Store
+	logger *slog.Logger
+
+	consecutiveFailures int
+	errorRate           float64
+	minRequests         int
+	interval            time.Duration
+	baseEjectionTime    time.Duration
+	maxEjectionTime     time.Duration
+	maxEjectionPercent  int
+
+	lock  sync.Mutex
+	stats map[string]*stats
+	now   func() time.Time
+}
+
+type stats struct {
+	consecutiveFailures int
+	requests            int
+	failures            int
+	windowStart         time.Time
+	// ejections is the multiplier of the ejection time, it decays while the backend behaves
+	ejections    int
+	ejectedUntil time.Time
+}
+
+func NewDetector(store store.Store, logger *slog.Logger, cfg *config.Config) *Detector {
+	return &Detector{
+		store:               store,
+		logger:              logger,
+		consecutiveFailures: cfg.OutlierConsecutiveFailures,
+		errorRate:           cfg.OutlierErrorRate,
+		minRequests:         cfg.OutlierMinRequests,
+		interval:            cfg.OutlierInterval,
+		baseEjectionTime:    cfg.OutlierBaseEjectionTime,
+		maxEjectionTime:     cfg.OutlierMaxEjectionTime,
+		maxEjectionPercent:  cfg.OutlierMaxEjectionPercent,
+		stats:               make(map[string]*stats),
+		now:                 time.Now,
+	}
+}
+
+// Loop forgets the statistics of backends that have left the pool, it runs on every replica
+func (d *Detector) Loop() {
+	if d == nil || d.baseEjectionTime <= 0 {
+		return
+	}
+
+	for range time.Tick(pruneInterval) {
+		d.prune(context.Background())
+	}
+}
+
+func (d *Detector) prune(ctx context.Context) {
+	backends, err := d.store.All(ctx)
+	if err != nil {
+		d.logger.ErrorContext(ctx, "cannot get backends to prune outlier statistics", "error", err)
+		return
+	}
+
+	seen := make(map[string]bool, len(backends))
+	for _, b := range backends {
+		seen[b.URL()] = true
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for id := range d.stats {
+		if !seen[id] {
+			delete(d.stats, id)
+		}
+	}
+}
+
+func (d *Detector) Success(ctx context.Context, id string) {
+	d.record(ctx, id, false)
+}
+
+func (d *Detector) Failure(ctx context.Context, id string) {
+	d.record(ctx, id, true)
+}
+
+func (d *Detector) record(ctx context.Context, id string, failed bool) {
+	if d == nil || d.baseEjectionTime <= 0 {
+		return
+	}
+
+	d.lock.Lock()
+
+	now := d.now()
+
+	s, ok := d.stats[id]
+	if !ok {
+		s = &stats{windowStart: now}
+		d.stats[id] = s
+	}
+
+	if d.interval > 0 && now.Sub(s.windowStart) >= d.interval {
+		s.requests = 0
+		s.failures = 0
+		s.windowStart = now
+
+		if s.ejections > 0 && now.After(s.ejectedUntil) {
+			s.ejections--
+		}
+	}
+
+	s.requests++
+
+	if !failed {
+		s.consecutiveFailures = 0
+		d.lock.Unlock()
+		return
+	}
+
+	s.failures++
+	s.consecutiveFailures++
+
+	if !d.isOutlier(s) || now.Before(s.ejectedUntil) {
+		d.lock.Unlock()
+		return
+	}
+
+	duration := d.baseEjectionTime * time.Duration(s.ejections+1)
+	if d.maxEjectionTime > 0 {
+		duration = min(duration, d.maxEjectionTime)
+	}
+
+	d.lock.Unlock()
+
+	if !d.eject(ctx, id, now.Add(duration)) {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	s.ejections++
+	s.ejectedUntil = now.Add(duration)
+	s.consecutiveFailures = 0
+	s.requests = 0
+	s.failures = 0
+	s.windowStart = now
+}
+
+func (d *Detector) isOutlier(s *stats) bool {
+	if d.consecutiveFailures > 0 && s.consecutiveFailures >= d.consecutiveFailures {
+		return true
+	}
+
+	return d.errorRate > 0 &&
+		s.requests >= max(1, d.minRequests) &&
+		float64(s.failures)/float64(s.requests) >= d.errorRate
+}
+
+func (d *Detector) eject(ctx context.Context, id string, until time.Time) bool {
+	backends, err := d.store.All(ctx)
+	if err != nil {
+		d.logger.ErrorContext(ctx, "cannot get backends for outlier detection", "error", err)
+		return false
+	}
+
+	ejected := 0
+	for _, b := range backends {
+		if b.URL() == id && b.Ejected() {
+			// another replica was faster
+			return false
+		}
+
+		if b.Ejected() {
+			ejected++
+		}
+	}
+
+	// any percentage allows at least one ejection, 0 disables them
+	allowed := 0
+	if d.maxEjectionPercent > 0 {
+		allowed = max(1, len(backends)*d.maxEjectionPercent/100)
+	}
+
+	if ejected >= allowed {
+		d.logger.WarnContext(
+			ctx,
+			"not ejecting outlier, too many backends are ejected already",
+			"url", id,
+			"ejected", ejected,
+		)
+		return false
+	}
+
+	if err = d.store.Update(ctx, id, func(b *backend.Backend) {
+		b.Eject(until)
+	}); err != nil {
+		d.logger.ErrorContext(ctx, "cannot eject backend", "url", id, "error", err)
+		return false
+	}
+
+	d.logger.WarnContext(ctx, "ejected outlier", "url", id, "until", until)
+
+	return true
+}
@@ -0,0 +1,111 @@
+package outlier
+
+import (
+	"context"
+	"lb-9000/lb-9000/internal/backend"
+	"lb-9000/lb-9000/internal/config"
+	"lb-9000/lb-9000/internal/store/memory"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetector(t *testing.T) {
+	ctx := context.Background()
+	backendStore := memory.New(slog.Default())
+
+	for _, id := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"} {
+		assert.NoError(t, backendStore.Add(ctx, backend.NewBackend(id, id)))
+	}
+
+	detector := NewDetector(backendStore, slog.Default(), &config.Config{
+		OutlierConsecutiveFailures: 3,
+		OutlierBaseEjectionTime:    time.Minute,
+		OutlierMaxEjectionTime:     90 * time.Second,
+		OutlierMaxEjectionPercent:  25,
+	})
+
+	ejectedUntil := func(id string) time.Time {
+		backends, err := backendStore.All(ctx)
+		assert.NoError(t, err)
+
+		for _, b := range backends {
+			if b.URL() == id {
+				return b.EjectedUntil()
+			}
+		}
+
+		return time.Time{}
+	}
+
+	detector.Failure(ctx, "10.0.0.1")
+	detector.Failure(ctx, "10.0.0.1")
+	detector.Success(ctx, "10.0.0.1")
+	detector.Failure(ctx, "10.0.0.1")
+	detector.Failure(ctx, "10.0.0.1")
+	assert.True(t, ejectedUntil("10.0.0.1").IsZero())
+
+	detector.Failure(ctx, "10.0.0.1")
+	firstEjection := ejectedUntil("10.0.0.1")
+	assert.WithinDuration(t, time.Now().Add(time.Minute), firstEjection, time.Second)
+
+	// only a quarter of the pool may be ejected at once
+	for range 3 {
+		detector.Failure(ctx, "10.0.0.2")
+	}
+	assert.True(t, ejectedUntil("10.0.0.2").IsZero())
+
+	// the ejection time grows with every ejection, but is capped
+	detector.now = func() time.Time {
+		return time.Now().Add(2 * time.Minute)
+	}
+	assert.NoError(t, backendStore.Update(ctx, "10.0.0.1", func(b *backend.Backend) {
+		b.Eject(time.Time{})
+	}))
+
+	for range 3 {
+		detector.Failure(ctx, "10.0.0.1")
+	}
+	assert.WithinDuration(t, time.Now().Add(2*time.Minute+90*time.Second), ejectedUntil("10.0.0.1"), time.Second)
+}
+
+func TestDetectorWithoutEjections(t *testing.T) {
+	ctx := context.Background()
+	backendStore := memory.New(slog.Default())
+	assert.NoError(t, backendStore.Add(ctx, backend.NewBackend("10.0.0.1", "10.0.0.1")))
+
+	detector := NewDetector(backendStore, slog.Default(), &config.Config{
+		OutlierConsecutiveFailures: 1,
+		OutlierBaseEjectionTime:    time.Minute,
+		OutlierMaxEjectionPercent:  0,
+	})
+
+	detector.Failure(ctx, "10.0.0.1")
+
+	b, err := backendStore.Get(ctx, "10.0.0.1")
+	assert.NoError(t, err)
+	assert.False(t, b.Ejected())
+}
+
+func TestDetectorPrune(t *testing.T) {
+	ctx := context.Background()
+	backendStore := memory.New(slog.Default())
+	assert.NoError(t, backendStore.Add(ctx, backend.NewBackend("10.0.0.1", "10.0.0.1")))
+	assert.NoError(t, backendStore.Add(ctx, backend.NewBackend("10.0.0.2", "10.0.0.2")))
+
+	detector := NewDetector(backendStore, slog.Default(), &config.Config{
+		OutlierConsecutiveFailures: 3,
+		OutlierBaseEjectionTime:    time.Minute,
+	})
+
+	detector.Failure(ctx, "10.0.0.1")
+	detector.Failure(ctx, "10.0.0.2")
+	assert.NoError(t, backendStore.Remove(ctx, "10.0.0.2"))
+
+	detector.prune(ctx)
+
+	assert.Contains(t, detector.stats, "10.0.0.1")
+	assert.NotContains(t, detector.stats, "10.0.0.2")
+}
@@ -0,0 +1,53 @@
+package pool
+
+import (
+	"context"
+	"time"
+)
+
+// startReaper removes draining backends from the store once they
+// have no requests in flight anymore or the drain timeout has expired
+func (p *Pool) startReaper() {
+	for range time.Tick(p.refreshRate) {
+		if !p.elector.IsLeader() {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), p.refreshRate)
+		p.reap(ctx)
+		cancel()
+	}
+}
+
+func (p *Pool) reap(ctx context.Context) {
+	backends, err := p.backendStore.All(ctx)
+	if err != nil {
+		p.logger.Error("cannot get backends to reap", "error", err)
+		return
+	}
+
+	for _, backend := range backends {
+		if !backend.Draining() {
+			continue
+		}
+
+		drainingFor := time.Since(backend.DrainingSince())
+
+		switch {
+		case backend.Count() == 0:
+			p.logger.Info("backend drained, it is safe to terminate", "url", backend.URL(), "drainingFor", drainingFor)
+		case p.drainTimeout > 0 && drainingFor >= p.drainTimeout:
+			p.logger.Warn(
+				"drain timeout expired, removing backend with requests in flight",
+				"url", backend.URL(),
+				"requests", backend.Count(),
+			)
+		default:
+			continue
+		}
+
+		if err = p.backendStore.Remove(ctx, backend.URL()); err != nil {
+			p.logger.Error("error removing drained backend", "url", backend.URL(), "error", err)
+		}
+	}
+}
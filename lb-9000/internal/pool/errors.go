@@ -0,0 +1,196 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"lb-9000/lb-9000/internal/httperror"
+	"lb-9000/lb-9000/internal/metrics"
+	"lb-9000/lb-9000/internal/queue"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrNotInitialized = errors.New("pool not initialized")
+	ErrNoBackends     = errors.New("no backends available")
+
+	errUnroutable = errors.New("request could not be directed")
+)
+
+// unroutableHost is where requests the director failed to direct without a request state are sent,
+// the transport turns them into an error without dialing
+const unroutableHost = "unroutable.invalid"
+
+// electionError is returned when the pool could not pick a backend for the request
+type electionError struct {
+	err error
+}
+
+func (e *electionError) Error() string {
+	return "electing a backend: " + e.err.Error()
+}
+
+func (e *electionError) Unwrap() error {
+	return e.err
+}
+
+type stateKey struct{}
+
+// requestState is shared between the director, the transport and the error handler of a single request
+type requestState struct {
+	err   error
+	start time.Time
+	// backend holds one in-flight request of this request while it is set
+	backend string
+}
+
+func stateFrom(ctx context.Context) *requestState {
+	state, _ := ctx.Value(stateKey{}).(*requestState)
+	return state
+}
+
+// Handler wraps the reverse proxy, it must be used for the director to report failures
+// and for the in-flight requests to be released
+func (p *Pool) Handler(proxy http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		state := &requestState{start: time.Now()}
+		ctx := context.WithValue(request.Context(), stateKey{}, state)
+
+		// the request is done once its response was copied or it failed in any way, panics included
+		defer p.finish(ctx, state)
+
+		proxy.ServeHTTP(writer, request.WithContext(ctx))
+	})
+}
+
+// finish releases the backend the request still holds
+func (p *Pool) finish(ctx context.Context, state *requestState) {
+	if state.backend == "" {
+		return
+	}
+
+	p.release(ctx, state.backend, false)
+	state.backend = ""
+}
+
+// Transport short-circuits requests the director failed to direct, so they end up in the ErrorHandler
+func (p *Pool) Transport(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+		if state := stateFrom(request.Context()); state != nil && state.err != nil {
+			return nil, state.err
+		}
+
+		if request.URL.Host == unroutableHost {
+			return nil, &electionError{err: errUnroutable}
+		}
+
+		return p.roundTrip(next, request)
+	})
+}
+
+func (p *Pool) ErrorHandler(writer http.ResponseWriter, request *http.Request, err error) {
+	// the client going away is not the fault of the backend
+	if errors.Is(request.Context().Err(), context.Canceled) {
+		p.logger.DebugContext(request.Context(), "client canceled request", "error", err)
+		return
+	}
+
+	var election *electionError
+
+	switch {
+	case errors.Is(err, queue.ErrFull), errors.Is(err, queue.ErrTimeout):
+		p.logger.WarnContext(request.Context(), "rejecting request", "reason", err, "queueDepth", p.queue.Len())
+
+		retryAfter := int(math.Ceil(p.queue.Timeout().Seconds()))
+		writer.Header().Set("Retry-After", strconv.Itoa(max(1, retryAfter)))
+
+		reason := queue.ErrTimeout
+		if errors.Is(err, queue.ErrFull) {
+			reason = queue.ErrFull
+		}
+		p.writeError(writer, http.StatusServiceUnavailable, reason.Error())
+		return
+	case errors.As(err, &election):
+		// the error may come from the store, the client only learns that there is no backend for it
+		p.logger.ErrorContext(request.Context(), "cannot direct request", "error", err)
+
+		reason := ErrNoBackends
+		if errors.Is(err, ErrNotInitialized) {
+			reason = ErrNotInitialized
+		}
+		p.writeError(writer, http.StatusServiceUnavailable, reason.Error())
+		return
+	}
+
+	status := http.StatusBadGateway
+	if isTimeout(err) {
+		status = http.StatusGatewayTimeout
+		p.logger.ErrorContext(request.Context(), "upstream timed out", "error", err)
+		p.writeError(writer, status, "upstream timed out")
+	} else {
+		p.logger.ErrorContext(request.Context(), "error proxying request", "error", err)
+		p.writeError(writer, status, "upstream failed")
+	}
+
+	if id := p.backendOf(request); id != "" {
+		p.observe(request, id, status)
+		p.detector.Failure(context.WithoutCancel(request.Context()), id)
+	}
+}
+
+// backendOf returns the backend the request was sent to last, the director put it into the request state
+func (p *Pool) backendOf(request *http.Request) string {
+	if state := stateFrom(request.Context()); state != nil {
+		return state.backend
+	}
+
+	return ""
+}
+
+// observe records the outcome of a request that reached a backend
+func (p *Pool) observe(request *http.Request, id string, status int) {
+	code := strconv.Itoa(status)
+
+	metrics.Requests.WithLabelValues(id, code).Inc()
+
+	if state := stateFrom(request.Context()); state != nil {
+		metrics.RequestDuration.WithLabelValues(id, code).Observe(time.Since(state.start).Seconds())
+	}
+}
+
+// fail makes the transport skip the request and hand the error to the ErrorHandler
+func (p *Pool) fail(request *http.Request, err error) {
+	state := stateFrom(request.Context())
+	if state == nil {
+		// the pool handler is not in place, the transport cannot tell the request failed otherwise
+		p.logger.ErrorContext(request.Context(), "cannot direct request", "error", err)
+		request.URL.Scheme = "http"
+		request.URL.Host = unroutableHost
+		return
+	}
+
+	state.err = &electionError{err: err}
+}
+
+func (p *Pool) writeError(writer http.ResponseWriter, status int, message string) {
+	httperror.Write(writer, p.errorFormat, status, message)
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+type roundTripperFunc func(request *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
@@ -0,0 +1,230 @@
+package pool
+
+import (
+	"context"
+	"lb-9000/lb-9000/internal/accesslog"
+	"lb-9000/lb-9000/internal/config"
+	"lb-9000/lb-9000/internal/metrics"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// latencySamples is the size of the window the p95 of a path is computed from
+	latencySamples = 1000
+	// minLatencySamples are needed before the p95 is used as hedge delay
+	minLatencySamples = 20
+)
+
+// latencyWindow keeps the latest response times of a path
+type latencyWindow struct {
+	lock    sync.Mutex
+	samples []time.Duration
+	next    int
+	p95     time.Duration
+}
+
+func (w *latencyWindow) observe(duration time.Duration) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if len(w.samples) < latencySamples {
+		w.samples = append(w.samples, duration)
+	} else {
+		w.samples[w.next] = duration
+		w.next = (w.next + 1) % latencySamples
+	}
+
+	// sorting the window for every response would be wasteful
+	if len(w.samples) >= minLatencySamples && (len(w.samples) < latencySamples || w.next%50 == 0) {
+		sorted := slices.Clone(w.samples)
+		slices.Sort(sorted)
+		w.p95 = sorted[len(sorted)*95/100]
+	}
+}
+
+func (w *latencyWindow) percentile95() time.Duration {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.p95
+}
+
+func hedgeLatency(paths []string) map[string]*latencyWindow {
+	windows := map[string]*latencyWindow{}
+	for _, path := range paths {
+		if path = strings.TrimSpace(path); path != "" {
+			windows[path] = &latencyWindow{}
+		}
+	}
+
+	return windows
+}
+
+// hedgeWindow returns the latency window of the hedged path the request belongs to,
+// only requests without a body and with a safe method are hedged
+func (p *Pool) hedgeWindow(request *http.Request) (*latencyWindow, bool) {
+	if request.Body != nil && request.Body != http.NoBody {
+		return nil, false
+	}
+
+	if !slices.Contains([]string{http.MethodGet, http.MethodHead, http.MethodOptions}, request.Method) {
+		return nil, false
+	}
+
+	var match string
+	for path := range p.hedgeLatency {
+		if config.HasPathPrefix(request.URL.Path, path) && len(path) > len(match) {
+			match = path
+		}
+	}
+
+	if match == "" {
+		return nil, false
+	}
+
+	return p.hedgeLatency[match], true
+}
+
+type attempt struct {
+	response *http.Response
+	err      error
+	backend  string
+	cancel   context.CancelFunc
+}
+
+// hedge sends the request to a second backend when the first has not answered within the delay,
+// the first response wins and the other attempt is canceled
+func (p *Pool) hedge(next http.RoundTripper, request *http.Request, state *requestState, delay time.Duration) (*http.Response, error) {
+	results := make(chan attempt, 2)
+	cancels := map[string]context.CancelFunc{}
+
+	launch := func(attemptRequest *http.Request, backend string) {
+		ctx, cancel := context.WithCancel(request.Context())
+		cancels[backend] = cancel
+
+		go func() {
+			response, err := next.RoundTrip(attemptRequest.WithContext(ctx))
+			results <- attempt{response: response, err: err, backend: backend, cancel: cancel}
+		}()
+	}
+
+	launch(request, state.backend)
+	pending := 1
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	hedged := false
+
+	launchHedge := func() {
+		hedged = true
+
+		if hedgeRequest, backend, ok := p.hedgeRequest(request, state.backend); ok {
+			metrics.Hedges.WithLabelValues("launched").Inc()
+			launch(hedgeRequest, backend)
+			pending++
+		}
+	}
+
+	for {
+		var result attempt
+
+		select {
+		case <-timer.C:
+			if !hedged {
+				launchHedge()
+			}
+			continue
+		case result = <-results:
+			pending--
+		}
+
+		delete(cancels, result.backend)
+
+		if result.err == nil {
+			if result.backend != state.backend {
+				metrics.Hedges.WithLabelValues("won").Inc()
+			}
+
+			state.backend = result.backend
+			accesslog.SetBackend(request.Context(), result.backend)
+
+			for _, cancel := range cancels {
+				cancel()
+			}
+			go p.discard(request.Context(), results, pending)
+
+			result.response.Body = &cancelOnClose{ReadCloser: result.response.Body, cancel: result.cancel}
+
+			return result.response, nil
+		}
+
+		result.cancel()
+
+		// a backend that cannot be reached does not need to wait for the delay
+		if !hedged && isConnectionFailure(result.err) && request.Context().Err() == nil {
+			launchHedge()
+		}
+
+		if pending == 0 {
+			state.backend = result.backend
+			return nil, result.err
+		}
+
+		// the other attempt is still running, this one is done
+		p.release(request.Context(), result.backend, request.Context().Err() == nil)
+	}
+}
+
+// hedgeRequest elects another backend for the hedged attempt and counts the request there
+func (p *Pool) hedgeRequest(request *http.Request, primary string) (*http.Request, string, bool) {
+	ctx := request.Context()
+
+	elected, err := p.strategy.Elect(request, excluding{Store: p.backendStore, excluded: map[string]bool{primary: true}})
+	if err != nil || elected == nil || elected.URL() == "" {
+		p.logger.DebugContext(ctx, "no backend to hedge the request on", "error", err)
+		return nil, "", false
+	}
+
+	if err = p.addRequests(ctx, elected.URL(), 1); err != nil {
+		p.logger.ErrorContext(ctx, "error adding request to backend", "error", err)
+		return nil, "", false
+	}
+
+	hedgeRequest := request.Clone(ctx)
+	p.orchestration.DirectRequest(hedgeRequest, elected)
+
+	return hedgeRequest, elected.URL(), true
+}
+
+// discard waits for the attempts that lost and releases their backends
+func (p *Pool) discard(ctx context.Context, results chan attempt, pending int) {
+	for range pending {
+		result := <-results
+		result.cancel()
+
+		if result.err == nil {
+			_ = result.response.Body.Close()
+		}
+
+		p.release(ctx, result.backend, false)
+	}
+}
+
+// release takes the request of an attempt that is done off the backend
+func (p *Pool) release(ctx context.Context, backend string, failed bool) {
+	ctx = context.WithoutCancel(ctx)
+
+	if failed {
+		p.detector.Failure(ctx, backend)
+	}
+
+	if err := p.addRequests(ctx, backend, -1); err != nil {
+		p.logger.ErrorContext(ctx, "error removing request from backend", "error", err)
+	}
+
+	p.queue.Notify()
+}
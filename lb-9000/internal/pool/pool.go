@@ -3,13 +3,23 @@ package pool
 import (
 	"context"
 	"fmt"
+	"lb-9000/lb-9000/internal/accesslog"
+	"lb-9000/lb-9000/internal/affinity"
+	"lb-9000/lb-9000/internal/backend"
+	"lb-9000/lb-9000/internal/config"
 	"lb-9000/lb-9000/internal/election"
 	"lb-9000/lb-9000/internal/orchestration"
+	"lb-9000/lb-9000/internal/outlier"
+	"lb-9000/lb-9000/internal/queue"
+	"lb-9000/lb-9000/internal/requestid"
 	"lb-9000/lb-9000/internal/store"
 	"lb-9000/lb-9000/internal/strategy"
+	"lb-9000/lb-9000/internal/tracing"
 	"log/slog"
 	"net/http"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Pool struct {
@@ -17,11 +27,25 @@ type Pool struct {
 	strategy      strategy.Strategy
 	orchestration orchestration.Orchestration
 	elector       *election.Elector
+	detector      *outlier.Detector
+	affinity      *affinity.Cookie
+	queue         *queue.Queue
 
 	logger *slog.Logger
 
-	refreshRate time.Duration
-	initialized bool
+	errorFormat  string
+	refreshRate  time.Duration
+	drainTimeout time.Duration
+	initialized  bool
+	transform    config.Transform
+
+	retryAttempts      int
+	retryPerTryTimeout time.Duration
+	retryMaxBodySize   int64
+	retryBudget        *retryBudget
+
+	hedgeDelay   time.Duration
+	hedgeLatency map[string]*latencyWindow
 }
 
 func New(
@@ -29,68 +53,135 @@ func New(
 	strategy strategy.Strategy,
 	orchestration orchestration.Orchestration,
 	elector *election.Elector,
+	detector *outlier.Detector,
+	affinity *affinity.Cookie,
+	queue *queue.Queue,
 	logger *slog.Logger,
-	refreshRate time.Duration,
+	cfg *config.Config,
 ) *Pool {
 	return &Pool{
 		backendStore:  store,
 		strategy:      strategy,
 		orchestration: orchestration,
 		elector:       elector,
+		detector:      detector,
+		affinity:      affinity,
+		queue:         queue,
 		logger:        logger,
-		refreshRate:   refreshRate,
+		errorFormat:   cfg.ErrorFormat,
+		refreshRate:   cfg.RefreshRate,
+		drainTimeout:  cfg.DrainTimeout,
+		transform:     cfg.Transform,
+
+		retryAttempts:      cfg.RetryAttempts,
+		retryPerTryTimeout: cfg.RetryPerTryTimeout,
+		retryMaxBodySize:   cfg.RetryMaxBodySize,
+		retryBudget:        newRetryBudget(cfg.RetryBudget),
+
+		hedgeDelay:   cfg.HedgeDelay,
+		hedgeLatency: hedgeLatency(cfg.HedgePaths),
 	}
 }
 
 func (p *Pool) Director(request *http.Request) {
 	if !p.initialized {
-		panic("pool not initialized")
+		p.fail(request, ErrNotInitialized)
+		return
 	}
 
 	ctx := request.Context()
 
-	elected, err := p.strategy.Elect(ctx, p.backendStore)
+	electCtx, span := tracing.Start(ctx, "elect")
+	elected, err := p.elect(request.WithContext(electCtx))
+	if elected != nil {
+		span.SetAttributes(attribute.String("backend", elected.URL()))
+	}
+	tracing.End(span, err)
+
+	// every backend that could take the request is at its limit
+	if err == nil && elected == nil && p.queue != nil && p.saturated(ctx) {
+		elected, err = p.await(request)
+	}
+
 	if err != nil {
-		panic("electing a backend: " + err.Error())
+		p.fail(request, err)
+		return
 	}
-	if elected == nil {
-		panic("no pods available")
+	if elected == nil || elected.URL() == "" {
+		p.fail(request, ErrNoBackends)
+		return
 	}
 
 	minUrl := elected.URL()
 
-	if err = p.backendStore.AddRequests(ctx, minUrl, 1); err != nil {
-		p.logger.Error("error adding request to backend", "error", err)
+	if err = p.addRequests(ctx, minUrl, 1); err != nil {
+		p.fail(request, fmt.Errorf("adding request to backend: %w", err))
 		return
 	}
 
-	p.logger.Info(
-		"request directed to pod",
-		"podUrl", minUrl,
-		"requests", elected.Count(),
-		"instanceId", p.orchestration.InstanceID(),
-	)
-
-	if minUrl == "" {
-		p.logger.Error("error getting url from backend")
-		return
+	if state := stateFrom(ctx); state != nil {
+		state.backend = minUrl
 	}
 
+	accesslog.SetBackend(ctx, minUrl)
 	p.orchestration.DirectRequest(request, elected)
+	p.transformRequest(request)
+}
+
+// elect honours the affinity cookie as long as its backend can take requests
+// and falls back to the strategy otherwise
+func (p *Pool) elect(request *http.Request) (*backend.Backend, error) {
+	if p.affinity != nil {
+		if id, ok := p.affinity.Backend(request); ok {
+			pinned, err := p.backendStore.Get(request.Context(), id)
+			if err != nil {
+				p.logger.ErrorContext(request.Context(), "error getting pinned backend", "error", err)
+			} else if pinned != nil && pinned.Available() {
+				return pinned, nil
+			}
+		}
+	}
+
+	return p.strategy.Elect(request, p.backendStore)
+}
+
+func (p *Pool) addRequests(ctx context.Context, id string, n int64) error {
+	ctx, span := tracing.Start(ctx, "store.AddRequests", attribute.String("backend", id), attribute.Int64("n", n))
+	err := p.backendStore.AddRequests(ctx, id, n)
+	tracing.End(span, err)
+
+	return err
 }
 
 func (p *Pool) ModifyResponse(response *http.Response) error {
-	id, err := p.orchestration.GetBackendIDFromResponse(response)
-	if err != nil {
-		p.logger.Error("error getting id from response", "error", err)
+	id := p.backendOf(response.Request)
+	if id == "" {
+		p.logger.ErrorContext(response.Request.Context(), "response without a backend, the pool handler is not in place")
 		return nil
 	}
 
-	if err = p.backendStore.AddRequests(context.Background(), id, -1); err != nil {
-		p.logger.Error("error removing request from backend", "error", err)
-		return nil
+	if p.affinity != nil {
+		if pinned, ok := p.affinity.Backend(response.Request); !ok || pinned != id {
+			p.affinity.Set(response, id)
+		}
+	}
+
+	// the response carries the request id of the proxy already
+	response.Header.Del(requestid.Header)
+	p.transformResponse(response)
+
+	p.observe(response.Request, id, response.StatusCode)
+
+	// the request may already be canceled, the outcome counts regardless
+	ctx := context.WithoutCancel(response.Request.Context())
+
+	if response.StatusCode >= http.StatusInternalServerError {
+		p.detector.Failure(ctx, id)
+	} else {
+		p.detector.Success(ctx, id)
 	}
 
+	// the in-flight request is released by the handler once the body was copied
 	return nil
 }
 
@@ -99,38 +190,78 @@ func (p *Pool) Init() {
 		return
 	}
 
-	go p.elector.Loop()
+	// the elector is shared by the pools of all routes, its loop is started once by the caller.
+	// Every loop only does its work while this instance is the leader
+	go p.startObserver()
+	go p.startLogger()
+	go p.startReaper()
+
+	p.initialized = true
+}
+
+// startObserver watches the orchestrator for as long as this instance is the leader
+func (p *Pool) startObserver() {
+	for range time.Tick(p.refreshRate / 2) {
+		if !p.elector.IsLeader() {
+			continue
+		}
+
+		ctx, cancel := p.whileLeader()
+		p.logger.Info("observing backends")
+		p.orchestration.StartObserver(ctx, p.backendStore)
+		cancel()
+	}
+}
+
+// whileLeader returns a context that is canceled once this instance is no longer the leader
+func (p *Pool) whileLeader() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
 
 	go func() {
+		ticker := time.NewTicker(p.refreshRate / 2)
+		defer ticker.Stop()
 
-		for range time.Tick(p.refreshRate / 2) {
-			if p.elector.IsLeader() {
-				go p.startLogger()
-				p.logger.Info("observing backends")
-				p.orchestration.StartObserver(p.backendStore)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !p.elector.IsLeader() {
+					p.logger.Info("not the leader anymore, stopped observing backends")
+					cancel()
+					return
+				}
 			}
 		}
 	}()
 
-	p.initialized = true
+	return ctx, cancel
 }
 
 func (p *Pool) startLogger() {
 	for range time.Tick(p.refreshRate) {
+		if !p.elector.IsLeader() {
+			continue
+		}
+
 		ctx := context.Background()
 		ctx, cancel := context.WithTimeout(ctx, p.refreshRate)
 		iterator, err := p.backendStore.Iterate(ctx)
 		if err != nil {
 			p.logger.Error("cannot iterate backends", "error", err)
 			cancel()
-			return
+			continue
 		}
 
 		cancel()
 
 		for backend := range iterator {
-			p.logger.Info(fmt.Sprintf("pod '%s' has '%d' requests", backend.URL(), backend.Count()))
+			if backend.Draining() {
+				p.logger.Info(fmt.Sprintf("pod '%s' is draining and has '%d' requests", backend.URL(), backend.Count()))
+				continue
+			}
 
+			p.logger.Info(fmt.Sprintf("pod '%s' has '%d' requests", backend.URL(), backend.Count()))
 		}
 	}
 }
@@ -0,0 +1,584 @@
+package pool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"lb-9000/lb-9000/internal/backend"
+	"lb-9000/lb-9000/internal/config"
+	"lb-9000/lb-9000/internal/metrics"
+	"lb-9000/lb-9000/internal/queue"
+	"lb-9000/lb-9000/internal/requestid"
+	"lb-9000/lb-9000/internal/store"
+	"lb-9000/lb-9000/internal/store/memory"
+	"lb-9000/lb-9000/internal/strategy"
+	"lb-9000/lb-9000/internal/tracing"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type directOrchestration struct{}
+
+func (directOrchestration) StartObserver(context.Context, store.Store) {}
+
+func (directOrchestration) DirectRequest(request *http.Request, backend *backend.Backend) {
+	request.URL.Scheme = "http"
+	request.URL.Host = backend.URL()
+}
+
+func (directOrchestration) InstanceID() string {
+	return "test"
+}
+
+func newTestPool(backendStore store.Store) (*Pool, http.Handler) {
+	p := New(
+		backendStore,
+		strategy.FillHoles(),
+		directOrchestration{},
+		nil,
+		nil,
+		nil,
+		nil,
+		slog.Default(),
+		&config.Config{},
+	)
+	p.initialized = true
+
+	proxy := &httputil.ReverseProxy{
+		Director:       p.Director,
+		ModifyResponse: p.ModifyResponse,
+		ErrorHandler:   p.ErrorHandler,
+		Transport:      p.Transport(tracing.Transport(http.DefaultTransport)),
+	}
+
+	return p, tracing.Handler(requestid.Middleware(p.Handler(proxy)))
+}
+
+func TestErrorResponses(t *testing.T) {
+	backendStore := memory.New(slog.Default())
+	p, handler := newTestPool(backendStore)
+
+	unavailable := testutil.ToFloat64(metrics.ErrorResponses.WithLabelValues("503"))
+	badGateway := testutil.ToFloat64(metrics.ErrorResponses.WithLabelValues("502"))
+
+	serve := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		return recorder
+	}
+
+	recorder := serve()
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	var body map[string]any
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	assert.Equal(t, ErrNoBackends.Error(), body["error"])
+
+	// nothing is listening on the backend
+	closed := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	closed.Close()
+	assert.NoError(t, backendStore.Add(t.Context(), backend.NewBackend(closed.Listener.Addr().String(), "closed")))
+
+	assert.Equal(t, http.StatusBadGateway, serve().Code)
+
+	p.errorFormat = "html"
+	assert.NoError(t, backendStore.Remove(t.Context(), closed.Listener.Addr().String()))
+
+	recorder = serve()
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "<h1>503 Service Unavailable</h1>")
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.ErrorResponses.WithLabelValues("503"))-unavailable)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ErrorResponses.WithLabelValues("502"))-badGateway)
+}
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var upstream trace.SpanContext
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+		upstream = trace.SpanContextFromContext(ctx)
+	}))
+	defer server.Close()
+
+	backendStore := memory.New(slog.Default())
+	assert.NoError(t, backendStore.Add(t.Context(), backend.NewBackend(server.Listener.Addr().String(), "server")))
+
+	_, handler := newTestPool(backendStore)
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID().String())
+		spans[span.Name] = span
+	}
+
+	assert.Contains(t, spans, "proxy")
+	assert.Contains(t, spans, "elect")
+	assert.Contains(t, spans, "store.AddRequests")
+	assert.Equal(t, "00f067aa0ba902b7", spans["proxy"].Parent.SpanID().String())
+	assert.Equal(t, spans["proxy"].SpanContext.SpanID(), spans["elect"].Parent.SpanID())
+
+	// the backend continues the trace below the client span of the round trip
+	assert.Equal(t, spans["proxy"].SpanContext.TraceID(), upstream.TraceID())
+	assert.NotEqual(t, spans["proxy"].SpanContext.SpanID(), upstream.SpanID())
+}
+
+func TestRequestID(t *testing.T) {
+	var forwarded string
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		forwarded = request.Header.Get(requestid.Header)
+		writer.Header().Set(requestid.Header, forwarded)
+	}))
+	defer server.Close()
+
+	backendStore := memory.New(slog.Default())
+	assert.NoError(t, backendStore.Add(t.Context(), backend.NewBackend(server.Listener.Addr().String(), "server")))
+
+	_, handler := newTestPool(backendStore)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Len(t, forwarded, 32)
+	assert.Equal(t, []string{forwarded}, recorder.Header().Values(requestid.Header))
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set(requestid.Header, "job-42")
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	assert.Equal(t, "job-42", forwarded)
+	assert.Equal(t, []string{"job-42"}, recorder.Header().Values(requestid.Header))
+}
+
+func TestRetries(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		bodies = append(bodies, string(body))
+	}))
+	defer server.Close()
+
+	closed := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	closed.Close()
+
+	live := server.Listener.Addr().String()
+	dead := closed.Listener.Addr().String()
+
+	backendStore := memory.New(slog.Default())
+	assert.NoError(t, backendStore.Add(t.Context(), backend.NewBackend(live, "live")))
+	assert.NoError(t, backendStore.Add(t.Context(), backend.NewBackend(dead, "dead")))
+
+	// the dead backend has fewer requests, so it is elected first
+	assert.NoError(t, backendStore.AddRequests(t.Context(), live, 1))
+
+	p, handler := newTestPool(backendStore)
+	p.retryAttempts = 1
+	p.retryMaxBodySize = 8
+
+	serve := func(body string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("small"))
+	assert.Equal(t, []string{"small"}, bodies)
+
+	count := func(id string) int64 {
+		b, err := backendStore.Get(t.Context(), id)
+		assert.NoError(t, err)
+		return b.Count()
+	}
+
+	assert.Equal(t, int64(0), count(dead))
+	assert.Equal(t, int64(1), count(live))
+
+	// the body does not fit into the buffer, so the request is not retried
+	assert.Equal(t, http.StatusBadGateway, serve("far too large"))
+	assert.Len(t, bodies, 1)
+}
+
+func TestTimeoutRetries(t *testing.T) {
+	var fastRequests atomic.Int64
+	fast := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		fastRequests.Add(1)
+	}))
+	defer fast.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	backendStore := memory.New(slog.Default())
+	assert.NoError(t, backendStore.Add(t.Context(), backend.NewBackend(fast.Listener.Addr().String(), "fast")))
+	assert.NoError(t, backendStore.Add(t.Context(), backend.NewBackend(slow.Listener.Addr().String(), "slow")))
+
+	// the slow backend has fewer requests, so it is elected first
+	assert.NoError(t, backendStore.AddRequests(t.Context(), fast.Listener.Addr().String(), 1))
+
+	p, handler := newTestPool(backendStore)
+	p.retryAttempts = 1
+	p.retryMaxBodySize = 8
+	p.retryPerTryTimeout = 20 * time.Millisecond
+
+	serve := func(method string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, "/", strings.NewReader("small")))
+		return recorder.Code
+	}
+
+	// the slow backend may have processed the request, so it is not sent again
+	assert.Equal(t, http.StatusGatewayTimeout, serve(http.MethodPost))
+	assert.Equal(t, int64(0), fastRequests.Load())
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodPut))
+	assert.Equal(t, int64(1), fastRequests.Load())
+}
+
+func TestHedging(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		select {
+		case <-request.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	backendStore := memory.New(slog.Default())
+	assert.NoError(t, backendStore.Add(t.Context(), backend.NewBackend(slow.Listener.Addr().String(), "slow")))
+	assert.NoError(t, backendStore.Add(t.Context(), backend.NewBackend(fast.Listener.Addr().String(), "fast")))
+
+	// the slow backend has fewer requests, so it is elected first
+	assert.NoError(t, backendStore.AddRequests(t.Context(), fast.Listener.Addr().String(), 1))
+
+	p, handler := newTestPool(backendStore)
+	p.hedgeDelay = 20 * time.Millisecond
+	p.hedgeLatency = hedgeLatency([]string{"/api"})
+
+	start := time.Now()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/jobs", nil))
+
+	assert.Equal(t, "fast", recorder.Body.String())
+	assert.Less(t, time.Since(start), time.Second)
+
+	// the paths match whole segments
+	_, hedged := p.hedgeWindow(httptest.NewRequest(http.MethodGet, "/apis/jobs", nil))
+	assert.False(t, hedged)
+
+	count := func(server *httptest.Server) int64 {
+		b, err := backendStore.Get(t.Context(), server.Listener.Addr().String())
+		assert.NoError(t, err)
+		return b.Count()
+	}
+
+	assert.Equal(t, int64(1), count(fast))
+	assert.Eventually(t, func() bool { return count(slow) == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestTransforms(t *testing.T) {
+	var received *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		received = request
+		writer.Header().Set("Server", "backend")
+		writer.Header().Set("X-Internal", "secret")
+	}))
+	defer server.Close()
+
+	backendStore := memory.New(slog.Default())
+	assert.NoError(t, backendStore.Add(t.Context(), backend.NewBackend(server.Listener.Addr().String(), "server")))
+
+	p, handler := newTestPool(backendStore)
+	p.transform = config.Transform{
+		StripPrefix:   "/gpu",
+		ReplacePrefix: "/v1",
+		Request: config.HeaderTransform{
+			Set:    map[string]string{"X-Worker-Type": "gpu"},
+			Remove: []string{"Cookie", "X-Forwarded-For"},
+		},
+		Response: config.HeaderTransform{
+			Add:    map[string]string{"X-Route": "gpu"},
+			Remove: []string{"Server", "X-Internal"},
+		},
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "http://api.example.com/gpu/jobs?full=true", nil)
+	request.Header.Set("Cookie", "session=1")
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, "/v1/jobs", received.URL.Path)
+	assert.Equal(t, "full=true", received.URL.RawQuery)
+	assert.Equal(t, "gpu", received.Header.Get("X-Worker-Type"))
+	assert.Equal(t, "api.example.com", received.Header.Get("X-Forwarded-Host"))
+	assert.Equal(t, "http", received.Header.Get("X-Forwarded-Proto"))
+	assert.Empty(t, received.Header.Get("Cookie"))
+	assert.Empty(t, received.Header.Get("X-Forwarded-For"))
+
+	assert.Equal(t, "gpu", recorder.Header().Get("X-Route"))
+	assert.Empty(t, recorder.Header().Get("Server"))
+	assert.Empty(t, recorder.Header().Get("X-Internal"))
+
+	// the prefix only covers whole segments and the headers of a proxy in front are kept
+	request = httptest.NewRequest(http.MethodGet, "http://api.example.com/gpus", nil)
+	request.Header.Set("X-Forwarded-Proto", "https")
+	request.Header.Set("X-Forwarded-Host", "example.com")
+
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	assert.Equal(t, "/gpus", received.URL.Path)
+	assert.Equal(t, "example.com", received.Header.Get("X-Forwarded-Host"))
+	assert.Equal(t, "https", received.Header.Get("X-Forwarded-Proto"))
+}
+
+type panickingOrchestration struct {
+	directOrchestration
+}
+
+func (panickingOrchestration) DirectRequest(*http.Request, *backend.Backend) {
+	panic("cannot direct request")
+}
+
+func TestReleases(t *testing.T) {
+	// held serves the request on a pool with the single backend and returns its count afterwards
+	held := func(id string, serve func(handler http.Handler) int, status int, configure func(p *Pool)) int64 {
+		backendStore := memory.New(slog.Default())
+		assert.NoError(t, backendStore.Add(t.Context(), backend.NewBackend(id, "test")))
+
+		p, handler := newTestPool(backendStore)
+		if configure != nil {
+			configure(p)
+		}
+
+		assert.Equal(t, status, serve(handler))
+
+		b, err := backendStore.Get(t.Context(), id)
+		assert.NoError(t, err)
+
+		return b.Count()
+	}
+
+	serve := func(request *http.Request) func(handler http.Handler) int {
+		return func(handler http.Handler) int {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			return recorder.Code
+		}
+	}
+
+	released := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+		writer.(http.Flusher).Flush()
+
+		// the body is still being streamed
+		<-released
+		_, _ = writer.Write([]byte("done"))
+	}))
+	defer slow.Close()
+
+	hanging := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		<-request.Context().Done()
+	}))
+	defer hanging.Close()
+
+	closed := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	closed.Close()
+
+	// the request is held until the body was copied
+	streamed := make(chan int64)
+	go func() {
+		streamed <- held(slow.Listener.Addr().String(), serve(httptest.NewRequest(http.MethodGet, "/", nil)), http.StatusOK, nil)
+	}()
+
+	assert.Eventually(t, func() bool {
+		select {
+		case released <- struct{}{}:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(0), <-streamed)
+
+	// dial failure
+	assert.Equal(t, int64(0), held(closed.Listener.Addr().String(), serve(httptest.NewRequest(http.MethodGet, "/", nil)), http.StatusBadGateway, nil))
+
+	// dial failure after a retry on the same backend was impossible
+	assert.Equal(t, int64(0), held(closed.Listener.Addr().String(), serve(httptest.NewRequest(http.MethodGet, "/", nil)), http.StatusBadGateway, func(p *Pool) {
+		p.retryAttempts = 2
+	}))
+
+	// upstream timeout
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, int64(0), held(hanging.Listener.Addr().String(), serve(httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)), http.StatusGatewayTimeout, nil))
+
+	// per-try timeout
+	assert.Equal(t, int64(0), held(hanging.Listener.Addr().String(), serve(httptest.NewRequest(http.MethodGet, "/", nil)), http.StatusGatewayTimeout, func(p *Pool) {
+		p.retryAttempts = 1
+		p.retryPerTryTimeout = 50 * time.Millisecond
+	}))
+
+	// client cancellation
+	ctx, cancel = context.WithCancel(t.Context())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	assert.Equal(t, int64(0), held(hanging.Listener.Addr().String(), serve(httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)), http.StatusOK, nil))
+
+	// panic in the director
+	assert.Equal(t, int64(0), held(hanging.Listener.Addr().String(), func(handler http.Handler) int {
+		assert.Panics(t, func() {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		})
+		return http.StatusOK
+	}, http.StatusOK, func(p *Pool) {
+		p.orchestration = panickingOrchestration{}
+	}))
+}
+
+// hostOrchestration sends the requests of a backend to a host that has nothing to do with its id
+type hostOrchestration struct {
+	directOrchestration
+	hosts map[string]string
+}
+
+func (o hostOrchestration) DirectRequest(request *http.Request, backend *backend.Backend) {
+	request.URL.Scheme = "http"
+	request.URL.Host = o.hosts[backend.URL()]
+}
+
+func TestBackendFromContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusTeapot)
+	}))
+	defer server.Close()
+
+	id := "fd00:10:244::6"
+
+	backendStore := memory.New(slog.Default())
+	assert.NoError(t, backendStore.Add(t.Context(), backend.NewBackend(id, "ipv6")))
+
+	p, handler := newTestPool(backendStore)
+	p.orchestration = hostOrchestration{hosts: map[string]string{id: server.Listener.Addr().String()}}
+
+	before := testutil.ToFloat64(metrics.Requests.WithLabelValues(id, "418"))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusTeapot, recorder.Code)
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.Requests.WithLabelValues(id, "418")))
+}
+
+func TestQueue(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+
+	id := server.Listener.Addr().String()
+	backendStore := memory.New(slog.Default())
+
+	p, handler := newTestPool(backendStore)
+	p.queue = queue.New(&config.Config{QueueSize: 1, QueueTimeout: 100 * time.Millisecond})
+
+	serve := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		return recorder
+	}
+
+	// without a backend that could take the request, it fails right away
+	start := time.Now()
+	recorder := serve()
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), ErrNoBackends.Error())
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+
+	saturated := backend.NewBackend(id, "saturated")
+	saturated.SetMaxRequests(1)
+	assert.NoError(t, backendStore.Add(t.Context(), saturated))
+	assert.NoError(t, backendStore.AddRequests(t.Context(), id, 1))
+
+	// the backend stays at its limit until the wait times out
+	recorder = serve()
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "1", recorder.Header().Get("Retry-After"))
+	assert.Contains(t, recorder.Body.String(), queue.ErrTimeout.Error())
+
+	// the request is served once the backend has capacity again
+	time.AfterFunc(20*time.Millisecond, func() {
+		assert.NoError(t, backendStore.AddRequests(t.Context(), id, -1))
+		p.queue.Notify()
+	})
+
+	assert.Equal(t, http.StatusOK, serve().Code)
+	assert.Equal(t, 0, p.queue.Len())
+}
+
+// failingStore cannot count requests, as a store whose database is down
+type failingStore struct {
+	store.Store
+}
+
+func (failingStore) AddRequests(context.Context, string, int64) error {
+	return errors.New("dial tcp 10.0.0.1:6379: connect: connection refused")
+}
+
+func TestElectionErrors(t *testing.T) {
+	backendStore := memory.New(slog.Default())
+	assert.NoError(t, backendStore.Add(t.Context(), backend.NewBackend("10.0.0.1:8080", "one")))
+
+	_, handler := newTestPool(failingStore{Store: backendStore})
+
+	// the error of the store stays in the logs
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), "6379")
+	assert.Contains(t, recorder.Body.String(), ErrNoBackends.Error())
+
+	// without the pool handler the request fails without a panic
+	p, _ := newTestPool(backendStore)
+	p.initialized = false
+
+	proxy := &httputil.ReverseProxy{
+		Director:     p.Director,
+		ErrorHandler: p.ErrorHandler,
+		Transport:    p.Transport(http.DefaultTransport),
+	}
+
+	recorder = httptest.NewRecorder()
+	proxy.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+}
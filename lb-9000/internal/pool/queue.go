@@ -0,0 +1,54 @@
+package pool
+
+import (
+	"context"
+	"lb-9000/lb-9000/internal/backend"
+	"lb-9000/lb-9000/internal/tracing"
+	"net/http"
+)
+
+// QueueDepth returns the number of waiting requests and the capacity of the queue
+func (p *Pool) QueueDepth() (int, int) {
+	return p.queue.Len(), p.queue.Size()
+}
+
+// await holds the request back in the queue until the election finds a backend with capacity.
+// A request that loses the capacity to another one after it was woken up goes on waiting
+func (p *Pool) await(request *http.Request) (*backend.Backend, error) {
+	ctx, span := tracing.Start(request.Context(), "queue")
+	request = request.WithContext(ctx)
+
+	var elected *backend.Backend
+	var err error
+
+	waitErr := p.queue.Wait(ctx, func(context.Context) bool {
+		elected, err = p.elect(request)
+		return err != nil || elected != nil
+	})
+	if waitErr != nil {
+		err = waitErr
+	}
+
+	tracing.End(span, err)
+
+	return elected, err
+}
+
+// saturated reports whether a backend could take the request once it has capacity again,
+// without one waiting is pointless and the request fails right away.
+// It is only called when the election found nothing, so the happy path does not scan the store
+func (p *Pool) saturated(ctx context.Context) bool {
+	iterator, err := p.backendStore.Iterate(ctx)
+	if err != nil {
+		p.logger.ErrorContext(ctx, "cannot iterate backends", "error", err)
+		return false
+	}
+
+	for b := range iterator {
+		if b.Healthy() && !b.Cordoned() && !b.Ejected() && !b.Draining() && b.Saturated() {
+			return true
+		}
+	}
+
+	return false
+}
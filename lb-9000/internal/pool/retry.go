@@ -0,0 +1,330 @@
+package pool
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"lb-9000/lb-9000/internal/accesslog"
+	"lb-9000/lb-9000/internal/backend"
+	"lb-9000/lb-9000/internal/metrics"
+	"lb-9000/lb-9000/internal/store"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// retryBurst is the number of retries the budget allows before requests have paid for them
+const retryBurst = 10
+
+// retryBudget limits retries to a share of the requests, so retries cannot multiply the load of an outage
+type retryBudget struct {
+	lock    sync.Mutex
+	ratio   float64
+	balance float64
+}
+
+func newRetryBudget(ratio float64) *retryBudget {
+	return &retryBudget{ratio: ratio, balance: retryBurst}
+}
+
+func (b *retryBudget) deposit() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.balance = min(retryBurst, b.balance+b.ratio)
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.balance < 1 {
+		return false
+	}
+
+	b.balance--
+
+	return true
+}
+
+// roundTrip hedges requests of the hedged paths and retries the others
+func (p *Pool) roundTrip(next http.RoundTripper, request *http.Request) (*http.Response, error) {
+	state := stateFrom(request.Context())
+	if state == nil {
+		return next.RoundTrip(request)
+	}
+
+	window, ok := p.hedgeWindow(request)
+	if !ok {
+		return p.retry(next, request, state)
+	}
+
+	delay := p.hedgeDelay
+	if delay <= 0 {
+		delay = window.percentile95()
+	}
+
+	start := time.Now()
+
+	var response *http.Response
+	var err error
+
+	// the p95 is not known until enough responses were seen
+	if delay > 0 {
+		response, err = p.hedge(next, request, state, delay)
+	} else {
+		response, err = p.retry(next, request, state)
+	}
+
+	if err == nil {
+		window.observe(time.Since(start))
+	}
+
+	return response, err
+}
+
+// retry sends the request and retries connection failures and per-try timeouts on other backends
+func (p *Pool) retry(next http.RoundTripper, request *http.Request, state *requestState) (*http.Response, error) {
+	if p.retryAttempts <= 0 {
+		return next.RoundTrip(request)
+	}
+
+	p.retryBudget.deposit()
+
+	attempt, replayable := p.replayable(request)
+	tried := map[string]bool{}
+
+	for retries := 0; ; retries++ {
+		response, timedOut, err := p.try(next, attempt)
+		if err == nil {
+			return response, nil
+		}
+
+		if !replayable || retries >= p.retryAttempts || request.Context().Err() != nil || !retriable(request, timedOut, err) {
+			return nil, err
+		}
+
+		if !p.retryBudget.withdraw() {
+			metrics.Retries.WithLabelValues("budget_exhausted").Inc()
+			p.logger.WarnContext(request.Context(), "not retrying, the retry budget is exhausted", "error", err)
+			return nil, err
+		}
+
+		retry, retryErr := p.redirect(attempt, state, tried)
+		if retryErr != nil {
+			p.logger.WarnContext(request.Context(), "cannot retry request", "error", retryErr)
+			return nil, err
+		}
+
+		metrics.Retries.WithLabelValues("retried").Inc()
+		p.logger.InfoContext(request.Context(), "retrying request on another backend", "error", err, "backend", state.backend)
+
+		attempt = retry
+	}
+}
+
+// redirect moves the request and its in-flight count from the failed backend to a new one
+func (p *Pool) redirect(attempt *http.Request, state *requestState, tried map[string]bool) (*http.Request, error) {
+	ctx := context.WithoutCancel(attempt.Context())
+
+	failed := state.backend
+	tried[failed] = true
+	state.backend = ""
+
+	// the freed slot may let a queued request through
+	p.release(ctx, failed, true)
+
+	elected, err := p.strategy.Elect(attempt, excluding{Store: p.backendStore, excluded: tried})
+	if err != nil {
+		return nil, fmt.Errorf("electing a backend: %w", err)
+	}
+	if elected == nil || elected.URL() == "" {
+		return nil, ErrNoBackends
+	}
+
+	if err = p.addRequests(ctx, elected.URL(), 1); err != nil {
+		return nil, fmt.Errorf("adding request to backend: %w", err)
+	}
+
+	state.backend = elected.URL()
+	accesslog.SetBackend(attempt.Context(), elected.URL())
+
+	retry := attempt.Clone(attempt.Context())
+	if attempt.GetBody != nil {
+		if retry.Body, err = attempt.GetBody(); err != nil {
+			return nil, fmt.Errorf("replaying body: %w", err)
+		}
+	}
+
+	p.orchestration.DirectRequest(retry, elected)
+
+	return retry, nil
+}
+
+// try sends a single attempt, it reports whether the attempt ran into the per-try timeout.
+// The timeout only covers the wait for the response headers, streaming the body may take longer
+func (p *Pool) try(next http.RoundTripper, request *http.Request) (*http.Response, bool, error) {
+	if p.retryPerTryTimeout <= 0 {
+		response, err := next.RoundTrip(request)
+		return response, false, err
+	}
+
+	ctx, cancel := context.WithCancel(request.Context())
+
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(p.retryPerTryTimeout, func() {
+		timedOut.Store(true)
+		cancel()
+	})
+
+	response, err := next.RoundTrip(request.WithContext(ctx))
+	if !timer.Stop() && err == nil {
+		_ = response.Body.Close()
+		err = context.DeadlineExceeded
+	}
+
+	if err != nil {
+		cancel()
+
+		if timedOut.Load() {
+			// the attempt was canceled by the timer, not by the client
+			err = fmt.Errorf("per-try timeout: %w", context.DeadlineExceeded)
+		}
+
+		return nil, timedOut.Load(), err
+	}
+
+	response.Body = &cancelOnClose{ReadCloser: response.Body, cancel: cancel}
+
+	return response, false, nil
+}
+
+// replayable buffers bodies up to RETRY_MAX_BODY_SIZE and reports whether the request may be sent again.
+// Idempotent requests can always be replayed, others only when their body could be buffered,
+// whether a failed attempt is actually retried is up to retriable
+func (p *Pool) replayable(request *http.Request) (*http.Request, bool) {
+	attempt := request.WithContext(request.Context())
+
+	if request.Body == nil || request.Body == http.NoBody {
+		return attempt, isIdempotent(request) || p.retryMaxBodySize > 0
+	}
+
+	if request.ContentLength > p.retryMaxBodySize {
+		return attempt, false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(request.Body, p.retryMaxBodySize+1))
+	if err != nil || int64(len(body)) > p.retryMaxBodySize {
+		// the part that was read goes first, the rest is streamed as usual
+		attempt.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), request.Body), request.Body}
+
+		return attempt, false
+	}
+
+	attempt.Body = io.NopCloser(bytes.NewReader(body))
+	attempt.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	return attempt, true
+}
+
+// retriable reports whether the failed attempt may be sent again, a backend that timed out may have
+// processed the request already, so only idempotent requests are retried after timeouts
+func retriable(request *http.Request, timedOut bool, err error) bool {
+	if timedOut {
+		return isIdempotent(request)
+	}
+
+	return isConnectionFailure(err)
+}
+
+func isIdempotent(request *http.Request) bool {
+	if slices.Contains([]string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete}, request.Method) {
+		return true
+	}
+
+	// same convention as net/http
+	return request.Header.Get("Idempotency-Key") != "" || request.Header.Get("X-Idempotency-Key") != ""
+}
+
+// isConnectionFailure reports whether the backend could not be reached, so nothing was sent to it
+func isConnectionFailure(err error) bool {
+	var opErr *net.OpError
+
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// excluding hides the backends that were tried already from the strategy
+type excluding struct {
+	store.Store
+	excluded map[string]bool
+}
+
+func (e excluding) Get(ctx context.Context, id string) (*backend.Backend, error) {
+	if e.excluded[id] {
+		return nil, nil
+	}
+
+	return e.Store.Get(ctx, id)
+}
+
+func (e excluding) Iterate(ctx context.Context) (iter.Seq[*backend.Backend], error) {
+	backends, err := e.Store.Iterate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(yield func(*backend.Backend) bool) {
+		for b := range backends {
+			if !e.excluded[b.URL()] && !yield(b) {
+				return
+			}
+		}
+	}, nil
+}
+
+func (e excluding) All(ctx context.Context) ([]*backend.Backend, error) {
+	backends, err := e.Store.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.filter(backends), nil
+}
+
+func (e excluding) Sample(ctx context.Context, n int) ([]*backend.Backend, error) {
+	backends, err := e.Store.Sample(ctx, n+len(e.excluded))
+	if err != nil {
+		return nil, err
+	}
+
+	backends = e.filter(backends)
+
+	return backends[:min(n, len(backends))], nil
+}
+
+func (e excluding) filter(backends []*backend.Backend) []*backend.Backend {
+	return slices.DeleteFunc(backends, func(b *backend.Backend) bool {
+		return e.excluded[b.URL()]
+	})
+}
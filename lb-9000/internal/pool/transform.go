@@ -0,0 +1,58 @@
+package pool
+
+import (
+	"lb-9000/lb-9000/internal/config"
+	"net/http"
+	"strings"
+)
+
+// transformRequest sets the forwarded headers and applies the request transform of the route
+func (p *Pool) transformRequest(request *http.Request) {
+	// the reverse proxy appends the client to X-Forwarded-For on its own,
+	// the other headers are kept when a proxy in front of this one set them already
+	if request.Header.Get("X-Forwarded-Proto") == "" {
+		proto := "http"
+		if request.TLS != nil {
+			proto = "https"
+		}
+		request.Header.Set("X-Forwarded-Proto", proto)
+	}
+	if request.Header.Get("X-Forwarded-Host") == "" {
+		request.Header.Set("X-Forwarded-Host", request.Host)
+	}
+
+	if prefix := p.transform.StripPrefix; prefix != "" && config.HasPathPrefix(request.URL.Path, prefix) {
+		path := p.transform.ReplacePrefix + strings.TrimPrefix(request.URL.Path, prefix)
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+
+		request.URL.Path = path
+		request.URL.RawPath = ""
+	}
+
+	transformHeaders(request.Header, p.transform.Request)
+}
+
+func (p *Pool) transformResponse(response *http.Response) {
+	transformHeaders(response.Header, p.transform.Response)
+}
+
+func transformHeaders(header http.Header, transform config.HeaderTransform) {
+	for _, name := range transform.Remove {
+		header.Del(name)
+
+		if http.CanonicalHeaderKey(name) == "X-Forwarded-For" {
+			// a nil value keeps the reverse proxy from adding the header again
+			header["X-Forwarded-For"] = nil
+		}
+	}
+
+	for name, value := range transform.Set {
+		header.Set(name, value)
+	}
+
+	for name, value := range transform.Add {
+		header.Add(name, value)
+	}
+}
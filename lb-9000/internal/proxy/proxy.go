@@ -2,27 +2,29 @@ package proxy
 
 import (
 	"context"
-	"lb-9000/lb-9000/internal/pool"
+	"lb-9000/lb-9000/internal/accesslog"
+	"lb-9000/lb-9000/internal/admin"
+	"lb-9000/lb-9000/internal/requestid"
+	"lb-9000/lb-9000/internal/router"
+	"lb-9000/lb-9000/internal/tracing"
 	"log/slog"
 	"net/http"
-	"net/http/httputil"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
-)
 
-func Start(pool *pool.Pool, port string) {
-	pool.Init()
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
 
-	proxy := &httputil.ReverseProxy{
-		Director:       pool.Director,
-		ModifyResponse: pool.ModifyResponse,
-	}
+func Start(router *router.Router, admin *admin.API, accessLog *accesslog.Logger, port string) {
+	router.Init()
 
 	go func() {
 		mux := http.NewServeMux()
 		mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {})
+		mux.Handle("GET /metrics", promhttp.Handler())
+		admin.Register(mux)
 		healthServer := http.Server{
 			Addr:              ":8081",
 			Handler:           mux,
@@ -36,7 +38,7 @@ func Start(pool *pool.Pool, port string) {
 
 	server := http.Server{
 		Addr:    ":" + port,
-		Handler: proxy,
+		Handler: tracing.Handler(requestid.Middleware(accessLog.Middleware(router))),
 		// todo config
 		ReadHeaderTimeout: 30 * time.Second,
 	}
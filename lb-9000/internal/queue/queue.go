@@ -0,0 +1,148 @@
+package queue
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"lb-9000/lb-9000/internal/config"
+	"sync"
+	"time"
+)
+
+// pollInterval is how often the head of the queue checks for capacity on its own,
+// requests finishing on other replicas do not notify this one
+const pollInterval = 50 * time.Millisecond
+
+var (
+	ErrFull    = errors.New("queue is full")
+	ErrTimeout = errors.New("timed out waiting in queue")
+)
+
+// Queue is a bounded FIFO queue for requests waiting for a backend with capacity
+type Queue struct {
+	size    int
+	timeout time.Duration
+
+	lock    sync.Mutex
+	waiters *list.List
+}
+
+// New returns nil when QUEUE_SIZE is not set, which disables queueing
+func New(cfg *config.Config) *Queue {
+	if cfg.QueueSize <= 0 {
+		return nil
+	}
+
+	return &Queue{
+		size:    cfg.QueueSize,
+		timeout: cfg.QueueTimeout,
+		waiters: list.New(),
+	}
+}
+
+// Wait returns once the caller is at the head of the queue and ready reports capacity
+func (q *Queue) Wait(ctx context.Context, ready func(ctx context.Context) bool) error {
+	if q.Len() == 0 && ready(ctx) {
+		return nil
+	}
+
+	wake := make(chan struct{}, 1)
+
+	q.lock.Lock()
+	if q.waiters.Len() >= q.size {
+		q.lock.Unlock()
+		return ErrFull
+	}
+	element := q.waiters.PushBack(wake)
+	q.lock.Unlock()
+
+	defer q.remove(element)
+
+	var timeout <-chan time.Time
+	if q.timeout > 0 {
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if q.isHead(element) && ready(ctx) {
+			return nil
+		}
+
+		select {
+		case <-wake:
+		case <-ticker.C:
+		case <-timeout:
+			return ErrTimeout
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Notify wakes up the head of the queue to check for capacity
+func (q *Queue) Notify() {
+	if q == nil {
+		return
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if head := q.waiters.Front(); head != nil {
+		select {
+		case head.Value.(chan struct{}) <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (q *Queue) Len() int {
+	if q == nil {
+		return 0
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.waiters.Len()
+}
+
+func (q *Queue) Size() int {
+	if q == nil {
+		return 0
+	}
+
+	return q.size
+}
+
+func (q *Queue) Timeout() time.Duration {
+	if q == nil {
+		return 0
+	}
+
+	return q.timeout
+}
+
+func (q *Queue) isHead(element *list.Element) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.waiters.Front() == element
+}
+
+// remove takes the waiter out of the queue and lets the next one check for capacity
+func (q *Queue) remove(element *list.Element) {
+	q.lock.Lock()
+	wasHead := q.waiters.Front() == element
+	q.waiters.Remove(element)
+	q.lock.Unlock()
+
+	if wasHead {
+		q.Notify()
+	}
+}
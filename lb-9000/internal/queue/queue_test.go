@@ -0,0 +1,71 @@
+package queue
+
+import (
+	"context"
+	"lb-9000/lb-9000/internal/config"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueue(t *testing.T) {
+	assert.Nil(t, New(&config.Config{}))
+
+	queue := New(&config.Config{QueueSize: 2, QueueTimeout: time.Second})
+	ctx := context.Background()
+
+	// capacity is handed out one slot at a time
+	slots := &atomic.Int64{}
+	ready := func(context.Context) bool {
+		for {
+			free := slots.Load()
+			if free == 0 {
+				return false
+			}
+			if slots.CompareAndSwap(free, free-1) {
+				return true
+			}
+		}
+	}
+
+	var (
+		lock  sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+
+	for i := range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, queue.Wait(ctx, ready))
+
+			lock.Lock()
+			order = append(order, i)
+			lock.Unlock()
+		}()
+
+		assert.Eventually(t, func() bool {
+			return queue.Len() == i+1
+		}, time.Second, time.Millisecond)
+	}
+
+	assert.ErrorIs(t, queue.Wait(ctx, ready), ErrFull)
+
+	for range 2 {
+		slots.Add(1)
+		queue.Notify()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, []int{0, 1}, order)
+	assert.Equal(t, 0, queue.Len())
+
+	queue = New(&config.Config{QueueSize: 1, QueueTimeout: 10 * time.Millisecond})
+	assert.ErrorIs(t, queue.Wait(ctx, ready), ErrTimeout)
+	assert.Equal(t, 0, queue.Len())
+}
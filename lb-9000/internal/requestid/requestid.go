@@ -0,0 +1,84 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+)
+
+const Header = "X-Request-ID"
+
+// maxLength bounds incoming ids, longer ones are replaced
+const maxLength = 128
+
+type key struct{}
+
+// From returns the id of the request handled with ctx, it is empty outside of a request
+func From(ctx context.Context) string {
+	id, _ := ctx.Value(key{}).(string)
+	return id
+}
+
+// Middleware reuses the incoming X-Request-ID or generates one, forwards it to the backend and echoes it in the response
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id := request.Header.Get(Header)
+		if !valid(id) {
+			id = generate()
+			request.Header.Set(Header, id)
+		}
+
+		writer.Header().Set(Header, id)
+
+		next.ServeHTTP(writer, request.WithContext(context.WithValue(request.Context(), key{}, id)))
+	})
+}
+
+func generate() string {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+
+	return hex.EncodeToString(id[:])
+}
+
+// valid only accepts printable ascii, the id ends up in logs and headers
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+
+	for i := range len(id) {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Handler adds the request id to every record logged with the context of a request
+type Handler struct {
+	slog.Handler
+}
+
+func NewHandler(inner slog.Handler) *Handler {
+	return &Handler{Handler: inner}
+}
+
+func (h *Handler) Handle(ctx context.Context, record slog.Record) error {
+	if id := From(ctx); id != "" {
+		record.AddAttrs(slog.String("requestId", id))
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,37 @@
+package requestid
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(NewHandler(slog.NewTextHandler(&out, nil))).With("component", "test")
+
+	var id string
+	handler := Middleware(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id = From(request.Context())
+		logger.InfoContext(request.Context(), "handling")
+	}))
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set(Header, "bad id\n")
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	assert.Len(t, id, 32)
+	assert.Equal(t, id, recorder.Header().Get(Header))
+	assert.Contains(t, out.String(), "component=test requestId="+id)
+
+	out.Reset()
+	logger.Info("outside of a request")
+	assert.False(t, strings.Contains(out.String(), "requestId"))
+}
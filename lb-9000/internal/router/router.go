@@ -0,0 +1,102 @@
+package router
+
+import (
+	"lb-9000/lb-9000/internal/config"
+	"lb-9000/lb-9000/internal/httperror"
+	"lb-9000/lb-9000/internal/pool"
+	"lb-9000/lb-9000/internal/tracing"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+)
+
+// Route sends the requests it matches to its own pool
+type Route struct {
+	Name  string
+	Pool  *pool.Pool
+	match config.Match
+
+	handler http.Handler
+}
+
+func NewRoute(name string, match config.Match, p *pool.Pool) *Route {
+	proxy := &httputil.ReverseProxy{
+		Director:       p.Director,
+		ModifyResponse: p.ModifyResponse,
+		ErrorHandler:   p.ErrorHandler,
+		Transport:      p.Transport(tracing.Transport(http.DefaultTransport)),
+	}
+
+	return &Route{
+		Name:    name,
+		Pool:    p,
+		match:   match,
+		handler: p.Handler(proxy),
+	}
+}
+
+func (r *Route) matches(request *http.Request) bool {
+	if r.match.Host != "" && !strings.EqualFold(r.match.Host, hostname(request.Host)) {
+		return false
+	}
+
+	if r.match.Method != "" && !strings.EqualFold(r.match.Method, request.Method) {
+		return false
+	}
+
+	if !config.HasPathPrefix(request.URL.Path, r.match.PathPrefix) {
+		return false
+	}
+
+	for name, value := range r.match.Headers {
+		if request.Header.Get(name) != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+func hostname(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		return name
+	}
+
+	return host
+}
+
+// Router hands requests to the first route that matches them
+type Router struct {
+	routes      []*Route
+	errorFormat string
+}
+
+func New(routes []*Route, cfg *config.Config) *Router {
+	return &Router{
+		routes:      routes,
+		errorFormat: cfg.ErrorFormat,
+	}
+}
+
+func (r *Router) Routes() []*Route {
+	return r.routes
+}
+
+// Init initializes the pools of all routes
+func (r *Router) Init() {
+	for _, route := range r.routes {
+		route.Pool.Init()
+	}
+}
+
+func (r *Router) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	for _, route := range r.routes {
+		if route.matches(request) {
+			route.handler.ServeHTTP(writer, request)
+			return
+		}
+	}
+
+	httperror.Write(writer, r.errorFormat, http.StatusNotFound, "no route matches the request")
+}
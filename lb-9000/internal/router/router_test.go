@@ -0,0 +1,44 @@
+package router
+
+import (
+	"lb-9000/lb-9000/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatches(t *testing.T) {
+	route := &Route{match: config.Match{
+		Host:       "api.example.com",
+		PathPrefix: "/jobs",
+		Method:     http.MethodPost,
+		Headers:    map[string]string{"X-Worker": "gpu"},
+	}}
+
+	request := func(method, target string, header string) *http.Request {
+		r := httptest.NewRequest(method, target, nil)
+		if header != "" {
+			r.Header.Set("X-Worker", header)
+		}
+		return r
+	}
+
+	assert.True(t, route.matches(request(http.MethodPost, "http://api.example.com:8080/jobs/1", "gpu")))
+	assert.False(t, route.matches(request(http.MethodPost, "http://other.example.com/jobs/1", "gpu")))
+	assert.False(t, route.matches(request(http.MethodPost, "http://api.example.com/status", "gpu")))
+	assert.False(t, route.matches(request(http.MethodPost, "http://api.example.com/jobsearch", "gpu")))
+	assert.False(t, route.matches(request(http.MethodGet, "http://api.example.com/jobs/1", "gpu")))
+	assert.False(t, route.matches(request(http.MethodPost, "http://api.example.com/jobs/1", "cpu")))
+
+	assert.True(t, (&Route{}).matches(request(http.MethodGet, "/anything", "")))
+}
+
+func TestNoRoute(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	New(nil, &config.Config{}).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.JSONEq(t, `{"status": 404, "error": "no route matches the request"}`, recorder.Body.String())
+}
@@ -7,6 +7,7 @@ import (
 	"iter"
 	"lb-9000/lb-9000/internal/backend"
 	"log/slog"
+	"math/rand/v2"
 	"sync"
 )
 
@@ -34,6 +35,13 @@ func (m *Map) Add(_ context.Context, backend *backend.Backend) error {
 
 	m.logger.Info("adding", "url", url, "name", name)
 
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if existing, ok := m.inner[url]; ok {
+		backend.Restore(existing)
+	}
+
 	m.inner[url] = backend
 
 	return nil
@@ -45,16 +53,30 @@ func (m *Map) Remove(_ context.Context, id string) error {
 	}
 
 	m.logger.Info(fmt.Sprintf("pod '%s' deleted", id))
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	delete(m.inner, id)
 
 	return nil
 }
 
+func (m *Map) Get(_ context.Context, id string) (*backend.Backend, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.inner[id], nil
+}
+
 func (m *Map) AddRequests(_ context.Context, id string, delta int64) error {
 	if id == "" {
 		return fmt.Errorf("id is empty")
 	}
 
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	instance, ok := m.inner[id]
 	if !ok {
 		return fmt.Errorf("could not find backend")
@@ -65,10 +87,50 @@ func (m *Map) AddRequests(_ context.Context, id string, delta int64) error {
 	return nil
 }
 
+func (m *Map) Update(_ context.Context, id string, fn func(backend *backend.Backend)) error {
+	if id == "" {
+		return fmt.Errorf("id is empty")
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	instance, ok := m.inner[id]
+	if !ok {
+		return fmt.Errorf("could not find backend")
+	}
+
+	// the stored records are handed out without the lock, so they are replaced instead of changed
+	updated := instance.Clone()
+	fn(updated)
+	m.inner[id] = updated
+
+	return nil
+}
+
 func (m *Map) All(_ context.Context) ([]*backend.Backend, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	return maps.Values(m.inner), nil
 }
 
+func (m *Map) Sample(_ context.Context, n int) ([]*backend.Backend, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	backends := maps.Values(m.inner)
+	n = min(n, len(backends))
+
+	// partial fisher-yates, only the first n positions are shuffled
+	for i := range n {
+		j := i + rand.IntN(len(backends)-i)
+		backends[i], backends[j] = backends[j], backends[i]
+	}
+
+	return backends[:n], nil
+}
+
 func (m *Map) Iterate(context.Context) (iter.Seq[*backend.Backend], error) {
 	return func(yield func(*backend.Backend) bool) {
 		m.lock.Lock()
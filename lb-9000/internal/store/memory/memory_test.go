@@ -0,0 +1,47 @@
+package memory
+
+import (
+	"lb-9000/lb-9000/internal/backend"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateWhileReading(t *testing.T) {
+	store := New(slog.Default())
+	assert.NoError(t, store.Add(t.Context(), backend.NewBackend("10.0.0.1", "one")))
+
+	read, err := store.Get(t.Context(), "10.0.0.1")
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for range 100 {
+			assert.NoError(t, store.Update(t.Context(), "10.0.0.1", func(b *backend.Backend) {
+				b.SetHealthy(!b.Healthy())
+			}))
+		}
+	}()
+
+	// records that were handed out are never changed, run with -race
+	for range 100 {
+		backends, err := store.All(t.Context())
+		assert.NoError(t, err)
+		assert.Len(t, backends, 1)
+		_ = backends[0].Healthy()
+	}
+
+	wg.Wait()
+
+	assert.True(t, read.Healthy())
+
+	// the counter is still shared with the updated record
+	assert.NoError(t, store.AddRequests(t.Context(), "10.0.0.1", 1))
+	assert.Equal(t, int64(1), read.Count())
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"lb-9000/lb-9000/internal/config"
+	"lb-9000/lb-9000/internal/election"
+	"log/slog"
+	"time"
+)
+
+// Leaser is implemented by stores shared by several instances. Every instance holds a lease
+// on what it added to the counters, so the leader can take back what dead instances left
+type Leaser interface {
+	// Renew extends the lease of this instance, the first call takes back what a previous run left
+	Renew(ctx context.Context, ttl time.Duration) error
+	// Reclaim subtracts the contributions of the instances whose lease expired and returns them
+	Reclaim(ctx context.Context) ([]string, error)
+}
+
+// Reconciler keeps the lease of this instance and, on the leader, repairs the counters of dead instances
+type Reconciler struct {
+	leaser  Leaser
+	elector *election.Elector
+	logger  *slog.Logger
+	ttl     time.Duration
+}
+
+// NewReconciler returns nil when the store is not shared or COUNTER_LEASE_TTL is 0
+func NewReconciler(store Store, elector *election.Elector, logger *slog.Logger, cfg *config.Config) *Reconciler {
+	leaser, ok := store.(Leaser)
+	if !ok || cfg.CounterLeaseTTL <= 0 {
+		return nil
+	}
+
+	return &Reconciler{
+		leaser:  leaser,
+		elector: elector,
+		logger:  logger,
+		ttl:     cfg.CounterLeaseTTL,
+	}
+}
+
+// Renew takes the lease, it must be called once before requests are counted
+func (r *Reconciler) Renew(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+
+	return r.leaser.Renew(ctx, r.ttl)
+}
+
+func (r *Reconciler) Loop() {
+	if r == nil {
+		return
+	}
+
+	// renewing a few times per ttl survives a missed renewal
+	for range time.Tick(r.ttl / 3) {
+		r.reconcile(context.Background())
+	}
+}
+
+func (r *Reconciler) reconcile(ctx context.Context) {
+	if err := r.leaser.Renew(ctx, r.ttl); err != nil {
+		r.logger.Error("cannot renew counter lease", "error", err)
+	}
+
+	if r.elector != nil && !r.elector.IsLeader() {
+		return
+	}
+
+	reclaimed, err := r.leaser.Reclaim(ctx)
+	if err != nil {
+		r.logger.Error("cannot reclaim counters", "error", err)
+	}
+
+	for _, instance := range reclaimed {
+		r.logger.Info("reclaimed counters of expired instance", "instanceId", instance)
+	}
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"context"
+	"lb-9000/lb-9000/internal/config"
+	"lb-9000/lb-9000/internal/store/memory"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeLeaser struct {
+	*memory.Map
+	renewals int
+	reclaims int
+}
+
+func (f *fakeLeaser) Renew(context.Context, time.Duration) error {
+	f.renewals++
+	return nil
+}
+
+func (f *fakeLeaser) Reclaim(context.Context) ([]string, error) {
+	f.reclaims++
+	return []string{"dead"}, nil
+}
+
+func TestReconciler(t *testing.T) {
+	cfg := &config.Config{CounterLeaseTTL: time.Second}
+
+	assert.Nil(t, NewReconciler(memory.New(slog.Default()), nil, slog.Default(), cfg))
+	assert.NoError(t, (*Reconciler)(nil).Renew(context.Background()))
+
+	leaser := &fakeLeaser{Map: memory.New(slog.Default())}
+	assert.Nil(t, NewReconciler(leaser, nil, slog.Default(), &config.Config{}))
+
+	reconciler := NewReconciler(leaser, nil, slog.Default(), cfg)
+	assert.NotNil(t, reconciler)
+
+	assert.NoError(t, reconciler.Renew(context.Background()))
+	reconciler.reconcile(context.Background())
+
+	assert.Equal(t, 2, leaser.renewals)
+	assert.Equal(t, 1, leaser.reclaims)
+}
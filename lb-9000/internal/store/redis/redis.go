@@ -2,55 +2,166 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"iter"
 	"lb-9000/lb-9000/internal/backend"
 	"lb-9000/lb-9000/internal/config"
 	"lb-9000/lb-9000/internal/utils"
 	"log/slog"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-const cacheTag = "backends"
+const (
+	cacheTag = "backends"
+	// countsTag is the hash of in-flight requests by backend, kept apart from the records
+	// so the hot path never rewrites them
+	countsTag = "counts"
+	// instancesTag is the set of instances that may have contributed to the counts
+	instancesTag = "instances"
+	// maxTxAttempts bounds how often a transaction is run again after another replica changed its keys
+	maxTxAttempts = 10
+)
+
+// addRequests increments the counter of a backend that still exists and clamps it at zero,
+// along with the contribution of the instance when there is one.
+// KEYS: the record, the counts hash, the contributions hash. ARGV: the backend id, the increment
+var addRequests = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	if KEYS[3] then
+		redis.call("HDEL", KEYS[3], ARGV[1])
+	end
+	return -1
+end
+
+local count = redis.call("HINCRBY", KEYS[2], ARGV[1], ARGV[2])
+if count < 0 then
+	redis.call("HSET", KEYS[2], ARGV[1], 0)
+	count = 0
+end
+
+if KEYS[3] then
+	if redis.call("HINCRBY", KEYS[3], ARGV[1], ARGV[2]) <= 0 then
+		redis.call("HDEL", KEYS[3], ARGV[1])
+	end
+end
+
+return count
+`)
+
+// reclaim subtracts the contributions of an instance from the counts, unless it holds its lease again.
+// KEYS: the contributions hash, the counts hash, the instances set, the lease. ARGV: the instance id
+var reclaim = redis.NewScript(`
+if redis.call("EXISTS", KEYS[4]) == 1 then
+	return -1
+end
+
+local contributions = redis.call("HGETALL", KEYS[1])
+for i = 1, #contributions, 2 do
+	-- removed backends have no counter left
+	if redis.call("HEXISTS", KEYS[2], contributions[i]) == 1 then
+		if redis.call("HINCRBY", KEYS[2], contributions[i], -tonumber(contributions[i + 1])) < 0 then
+			redis.call("HSET", KEYS[2], contributions[i], 0)
+		end
+	end
+end
+
+redis.call("DEL", KEYS[1])
+redis.call("SREM", KEYS[3], ARGV[1])
 
-func New(logger *slog.Logger, cfg *config.Config) *Redis {
+return #contributions / 2
+`)
+
+// New returns a store whose counters are shared by all instances,
+// without an instance id the contributions of this one are not tracked
+func New(logger *slog.Logger, cfg *config.Config, instanceID string) *Redis {
 	return &Redis{
-		redis:  utils.GetRedisClient(cfg),
-		logger: logger,
+		redis:      utils.GetRedisClient(cfg),
+		logger:     logger,
+		namespace:  cfg.StoreNamespace,
+		instanceID: instanceID,
 	}
 }
 
 type Redis struct {
-	redis  *redis.Client
-	logger *slog.Logger
+	redis      *redis.Client
+	logger     *slog.Logger
+	namespace  string
+	instanceID string
+	// leased is set once the leftovers of a previous run of the instance were reclaimed
+	leased bool
+}
+
+// key prefixes the key with the namespace, so several pools can share a database
+func (r *Redis) key(key string) string {
+	if r.namespace == "" {
+		return key
+	}
+
+	return r.namespace + ":" + key
 }
 
 func (r *Redis) All(ctx context.Context) ([]*backend.Backend, error) {
-	keys, err := r.redis.SMembers(ctx, cacheTag).Result()
+	keys, err := r.redis.SMembers(ctx, r.key(cacheTag)).Result()
 	if err != nil {
 		return nil, fmt.Errorf("getting keys by tag: %w", err)
 	}
 
-	backends, err := r.redis.MGet(ctx, keys...).Result()
+	return r.getMany(ctx, keys)
+}
+
+func (r *Redis) Sample(ctx context.Context, n int) ([]*backend.Backend, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	keys, err := r.redis.SRandMemberN(ctx, r.key(cacheTag), int64(n)).Result()
 	if err != nil {
+		return nil, fmt.Errorf("getting random keys by tag: %w", err)
+	}
+
+	return r.getMany(ctx, keys)
+}
+
+func (r *Redis) getMany(ctx context.Context, ids []string) ([]*backend.Backend, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, r.key(id))
+	}
+
+	pipe := r.redis.Pipeline()
+	records := pipe.MGet(ctx, keys...)
+	counts := pipe.HMGet(ctx, r.key(countsTag), ids...)
+
+	if _, err := pipe.Exec(ctx); err != nil {
 		return nil, fmt.Errorf("getting backends by keys (%s): %w", strings.Join(keys, ", "), err)
 	}
 
-	result := make([]*backend.Backend, 0, len(backends))
+	result := make([]*backend.Backend, 0, len(ids))
 
-	for _, backendCandidate := range backends {
+	for i, backendCandidate := range records.Val() {
 		if backendCandidate == nil {
 			continue
 		}
 
 		var b backend.Backend
 
-		if err = b.UnmarshalBinary([]byte(backendCandidate.(string))); err != nil {
+		if err := b.UnmarshalBinary([]byte(backendCandidate.(string))); err != nil {
 			return nil, fmt.Errorf("unmarshaling backend: %w", err)
 		}
 
+		if err := setCount(&b, counts.Val()[i]); err != nil {
+			return nil, err
+		}
+
 		result = append(result, &b)
 	}
 
@@ -73,56 +184,236 @@ func (r *Redis) Iterate(ctx context.Context) (iter.Seq[*backend.Backend], error)
 }
 
 func (r *Redis) Add(ctx context.Context, backend *backend.Backend) error {
-	pipe := r.redis.TxPipeline()
-
 	url := backend.URL()
 
-	pipe.SAdd(ctx, cacheTag, url)
-	pipe.Set(ctx, url, backend, 0)
+	if err := r.watch(ctx, func(tx *redis.Tx) error {
+		existing, err := r.get(ctx, tx, url)
+		if err != nil {
+			return err
+		}
 
-	if _, err := pipe.Exec(ctx); err != nil {
-		return fmt.Errorf("executing pipeline: %w", err)
+		if existing != nil {
+			backend.Restore(existing)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SAdd(ctx, r.key(cacheTag), url)
+			pipe.Set(ctx, r.key(url), backend, 0)
+			return nil
+		})
+
+		return err
+	}, r.key(url)); err != nil {
+		return fmt.Errorf("adding backend '%s': %w", url, err)
 	}
 
 	return nil
 }
 
 func (r *Redis) Remove(ctx context.Context, id string) error {
-	if _, err := r.redis.Del(ctx, id).Result(); err != nil {
+	pipe := r.redis.TxPipeline()
+
+	pipe.SRem(ctx, r.key(cacheTag), id)
+	pipe.Del(ctx, r.key(id))
+	pipe.HDel(ctx, r.key(countsTag), id)
+
+	if _, err := pipe.Exec(ctx); err != nil {
 		return fmt.Errorf("deleting backend '%s': %w", id, err)
 	}
 
 	return nil
 }
 
+func (r *Redis) Get(ctx context.Context, id string) (*backend.Backend, error) {
+	backends, err := r.getMany(ctx, []string{id})
+	if err != nil || len(backends) == 0 {
+		return nil, err
+	}
+
+	return backends[0], nil
+}
+
 func (r *Redis) AddRequests(ctx context.Context, id string, n int64) error {
-	if err := r.redis.Watch(ctx, func(tx *redis.Tx) error {
-		result, err := tx.Get(ctx, id).Result()
+	keys := []string{r.key(id), r.key(countsTag)}
+	if r.instanceID != "" {
+		keys = append(keys, r.contributionsKey(r.instanceID))
+	}
+
+	count, err := addRequests.Run(ctx, r.redis, keys, id, n).Int64()
+	if err != nil {
+		return fmt.Errorf("adding requests to backend '%s': %w", id, err)
+	}
+
+	if count < 0 {
+		// backend could be deleted here
+		r.logger.DebugContext(ctx, "backend not found", "id", id)
+	}
+
+	return nil
+}
+
+// Renew extends the lease of the instance on its contributions. The first call takes back
+// what a previous run with the same instance id left, so it must happen before requests are counted
+func (r *Redis) Renew(ctx context.Context, ttl time.Duration) error {
+	if r.instanceID == "" {
+		return nil
+	}
+
+	if !r.leased {
+		if err := r.redis.Del(ctx, r.leaseKey(r.instanceID)).Err(); err != nil {
+			return fmt.Errorf("releasing previous lease: %w", err)
+		}
+
+		if _, err := r.reclaim(ctx, r.instanceID); err != nil {
+			return fmt.Errorf("reclaiming previous contributions: %w", err)
+		}
+
+		r.leased = true
+	}
+
+	pipe := r.redis.TxPipeline()
+
+	pipe.SAdd(ctx, r.key(instancesTag), r.instanceID)
+	pipe.Set(ctx, r.leaseKey(r.instanceID), 1, ttl)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("renewing lease: %w", err)
+	}
+
+	return nil
+}
+
+// Reclaim subtracts the contributions of the instances whose lease expired and returns them
+func (r *Redis) Reclaim(ctx context.Context) ([]string, error) {
+	instances, err := r.redis.SMembers(ctx, r.key(instancesTag)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("getting instances: %w", err)
+	}
+
+	var reclaimed []string
+
+	for _, instance := range instances {
+		if instance == r.instanceID {
+			continue
+		}
+
+		ok, err := r.reclaim(ctx, instance)
 		if err != nil {
-			return fmt.Errorf("getting backend '%s': %w", id, err)
+			return reclaimed, fmt.Errorf("reclaiming contributions of '%s': %w", instance, err)
 		}
 
-		if result == "" {
-			// backend could be deleted here
-			r.logger.Debug("backend not found", "id", id)
-			return tx.Close(ctx)
+		if ok {
+			reclaimed = append(reclaimed, instance)
 		}
+	}
 
-		var b backend.Backend
-		if err = b.UnmarshalBinary([]byte(result)); err != nil {
-			return fmt.Errorf("unmarshaling backend: %w", err)
+	return reclaimed, nil
+}
+
+// reclaim returns false when the instance holds its lease
+func (r *Redis) reclaim(ctx context.Context, instance string) (bool, error) {
+	keys := []string{
+		r.contributionsKey(instance),
+		r.key(countsTag),
+		r.key(instancesTag),
+		r.leaseKey(instance),
+	}
+
+	n, err := reclaim.Run(ctx, r.redis, keys, instance).Int64()
+	if err != nil {
+		return false, err
+	}
+
+	return n >= 0, nil
+}
+
+func (r *Redis) contributionsKey(instance string) string {
+	return r.key("contributions:" + instance)
+}
+
+func (r *Redis) leaseKey(instance string) string {
+	return r.key("lease:" + instance)
+}
+
+// Update changes the record of a backend, its counter only changes through AddRequests
+func (r *Redis) Update(ctx context.Context, id string, fn func(backend *backend.Backend)) error {
+	if err := r.watch(ctx, func(tx *redis.Tx) error {
+		b, err := r.get(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if b == nil {
+			return fmt.Errorf("backend '%s' not found", id)
 		}
 
-		b.AddRequests(n)
+		fn(b)
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, r.key(id), b, 0)
+			return nil
+		})
 
-		if _, err = tx.Set(ctx, id, &b, 0).Result(); err != nil {
-			return fmt.Errorf("saving backend '%s': %w", id, err)
+		return err
+	}, r.key(id)); err != nil {
+		return fmt.Errorf("updating backend '%s': %w", id, err)
+	}
+
+	return nil
+}
+
+// watch runs the optimistic transaction fn and runs it again while other replicas change the watched keys in between
+func (r *Redis) watch(ctx context.Context, fn func(tx *redis.Tx) error, keys ...string) error {
+	var err error
+
+	for range maxTxAttempts {
+		if err = r.redis.Watch(ctx, fn, keys...); !errors.Is(err, redis.TxFailedErr) {
+			return err
 		}
+	}
 
+	return err
+}
+
+// get returns nil without an error when the backend does not exist,
+// its count is read from the counts hash like the ones of getMany
+func (r *Redis) get(ctx context.Context, cmd redis.Cmdable, id string) (*backend.Backend, error) {
+	pipe := cmd.Pipeline()
+	record := pipe.Get(ctx, r.key(id))
+	counts := pipe.HMGet(ctx, r.key(countsTag), id)
+
+	// a missing record fails the pipeline with redis.Nil
+	if _, err := pipe.Exec(ctx); errors.Is(err, redis.Nil) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("getting backend '%s': %w", id, err)
+	}
+
+	var b backend.Backend
+	if err := b.UnmarshalBinary([]byte(record.Val())); err != nil {
+		return nil, fmt.Errorf("unmarshaling backend: %w", err)
+	}
+
+	if err := setCount(&b, counts.Val()[0]); err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+// setCount applies a field of the counts hash, missing when no request was counted yet
+func setCount(b *backend.Backend, count any) error {
+	if count == nil {
+		b.SetCount(0)
 		return nil
-	}); err != nil {
-		return fmt.Errorf("watching backend '%s': %w", id, err)
 	}
 
+	n, err := strconv.ParseInt(count.(string), 10, 64)
+	if err != nil {
+		return fmt.Errorf("parsing count of backend '%s': %w", b.URL(), err)
+	}
+
+	b.SetCount(n)
+
 	return nil
 }
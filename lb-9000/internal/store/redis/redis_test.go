@@ -7,12 +7,16 @@ import (
 	"lb-9000/lb-9000/internal/backend"
 	"lb-9000/lb-9000/internal/config"
 	"log/slog"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func TestRedis(t *testing.T) {
+// newConfig starts a redis container for the test and returns a config pointing to it
+func newConfig(tb testing.TB) *config.Config {
 	request := testcontainers.ContainerRequest{
 		Image:        "redis:7.4.1-bookworm",
 		ExposedPorts: []string{"6379/tcp"},
@@ -28,27 +32,32 @@ func TestRedis(t *testing.T) {
 			Started:          true,
 		},
 	)
+	if !assert.NoError(tb, err) {
+		tb.FailNow()
+	}
 
-	assert.NoError(t, err)
-
-	defer func() {
-		if err = redisC.Terminate(ctx); err != nil {
-			assert.NoError(t, err)
-		}
-	}()
+	tb.Cleanup(func() {
+		assert.NoError(tb, redisC.Terminate(ctx))
+	})
 
 	endpoint, err := redisC.Endpoint(ctx, "")
+	assert.NoError(tb, err)
 
 	cfg, err := config.Parse("../../config/.env")
-	assert.NoError(t, err)
+	assert.NoError(tb, err)
 
 	cfg.StoreAddr = endpoint
 
-	store := New(slog.Default(), cfg)
+	return cfg
+}
+
+func TestRedis(t *testing.T) {
+	ctx := context.Background()
+	store := New(slog.Default(), newConfig(t), "test")
 
 	instance := backend.NewBackend("http://localhost:8080", "test")
 
-	err = store.Add(ctx, instance)
+	err := store.Add(ctx, instance)
 	assert.NoError(t, err)
 
 	err = store.AddRequests(ctx, instance.URL(), 1)
@@ -68,6 +77,18 @@ func TestRedis(t *testing.T) {
 
 	assert.Equal(t, 1, i)
 
+	// updates see the count of the counts hash, not the one stored with the record
+	var count int64
+	err = store.Update(ctx, instance.URL(), func(b *backend.Backend) {
+		count = b.Count()
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	sample, err := store.Sample(ctx, 2)
+	assert.NoError(t, err)
+	assert.Len(t, sample, 1)
+
 	err = store.Remove(ctx, instance.URL())
 	assert.NoError(t, err)
 
@@ -82,3 +103,144 @@ func TestRedis(t *testing.T) {
 
 	assert.Equal(t, 0, i)
 }
+
+func TestRedisConcurrentRequests(t *testing.T) {
+	ctx := context.Background()
+	store := New(slog.Default(), newConfig(t), "test")
+
+	instance := backend.NewBackend("http://localhost:8080", "test")
+	assert.NoError(t, store.Add(ctx, instance))
+
+	var wg sync.WaitGroup
+
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				assert.NoError(t, store.AddRequests(ctx, instance.URL(), 1))
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	b, err := store.Get(ctx, instance.URL())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), b.Count())
+
+	// metadata updates leave the counter alone
+	assert.NoError(t, store.Update(ctx, instance.URL(), func(b *backend.Backend) {
+		b.Cordon(true)
+	}))
+
+	assert.NoError(t, store.AddRequests(ctx, instance.URL(), -2000))
+
+	b, err = store.Get(ctx, instance.URL())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), b.Count())
+	assert.True(t, b.Cordoned())
+
+	// a removed backend is not brought back by late decrements
+	assert.NoError(t, store.Remove(ctx, instance.URL()))
+	assert.NoError(t, store.AddRequests(ctx, instance.URL(), -1))
+
+	b, err = store.Get(ctx, instance.URL())
+	assert.NoError(t, err)
+	assert.Nil(t, b)
+}
+
+func TestRedisConcurrentUpdates(t *testing.T) {
+	ctx := context.Background()
+	store := New(slog.Default(), newConfig(t), "")
+
+	instance := backend.NewBackend("http://localhost:8080", "test")
+	assert.NoError(t, store.Add(ctx, instance))
+
+	// every replica changes the same record, the transactions that lose the race run again.
+	// Each one loses at most once per other replica, which stays below maxTxAttempts
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, store.Update(ctx, instance.URL(), func(b *backend.Backend) {
+				b.SetManualWeight(b.ManualWeight() + 1)
+			}))
+		}()
+	}
+	wg.Wait()
+
+	b, err := store.Get(ctx, instance.URL())
+	assert.NoError(t, err)
+	assert.Equal(t, 5, b.ManualWeight())
+}
+
+func TestRedisReclaim(t *testing.T) {
+	ctx := context.Background()
+	cfg := newConfig(t)
+
+	alive := New(slog.Default(), cfg, "alive")
+	dead := New(slog.Default(), cfg, "dead")
+
+	instance := backend.NewBackend("http://localhost:8080", "test")
+	assert.NoError(t, alive.Add(ctx, instance))
+
+	assert.NoError(t, alive.Renew(ctx, time.Minute))
+	assert.NoError(t, dead.Renew(ctx, 100*time.Millisecond))
+
+	assert.NoError(t, alive.AddRequests(ctx, instance.URL(), 2))
+	assert.NoError(t, dead.AddRequests(ctx, instance.URL(), 3))
+
+	// the lease of the dead instance is still held
+	reclaimed, err := alive.Reclaim(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, reclaimed)
+
+	time.Sleep(200 * time.Millisecond)
+
+	reclaimed, err = alive.Reclaim(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dead"}, reclaimed)
+
+	b, err := alive.Get(ctx, instance.URL())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), b.Count())
+
+	// a restarted instance takes back what its previous run left
+	restarted := New(slog.Default(), cfg, "alive")
+	assert.NoError(t, restarted.Renew(ctx, time.Minute))
+
+	b, err = alive.Get(ctx, instance.URL())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), b.Count())
+}
+
+func BenchmarkAddRequests(b *testing.B) {
+	ctx := context.Background()
+	store := New(slog.Default(), newConfig(b), "test")
+
+	ids := make([]string, 4)
+	for i := range ids {
+		instance := backend.NewBackend("http://localhost:808"+strconv.Itoa(i), "test")
+		assert.NoError(b, store.Add(ctx, instance))
+		ids[i] = instance.URL()
+	}
+
+	b.SetParallelism(16)
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			id := ids[i%len(ids)]
+			if err := store.AddRequests(ctx, id, 1); err != nil {
+				b.Error(err)
+			}
+			if err := store.AddRequests(ctx, id, -1); err != nil {
+				b.Error(err)
+			}
+			i++
+		}
+	})
+}
@@ -13,17 +13,24 @@ import (
 type Store interface {
 	Add(ctx context.Context, backend *backend.Backend) error
 	Remove(ctx context.Context, id string) error
+	// Get returns nil without an error when the backend does not exist
+	Get(ctx context.Context, id string) (*backend.Backend, error)
 	AddRequests(ctx context.Context, id string, n int64) error
+	// Update applies fn to the stored backend and persists the result
+	Update(ctx context.Context, id string, fn func(backend *backend.Backend)) error
 	Iterate(ctx context.Context) (iter.Seq[*backend.Backend], error)
 	All(ctx context.Context) ([]*backend.Backend, error)
+	// Sample returns up to n distinct backends picked at random
+	Sample(ctx context.Context, n int) ([]*backend.Backend, error)
 }
 
-func Get(config *config.Config, logger *slog.Logger) Store {
+// Get returns the store of the config, the instance id keys what this instance adds to shared counters
+func Get(config *config.Config, instanceID string, logger *slog.Logger) Store {
 	switch config.StoreType {
 	case "memory":
 		return memory.New(logger)
 	case "redis":
-		return redis.New(logger, config)
+		return redis.New(logger, config, instanceID)
 	default:
 		panic("unknown store type")
 	}
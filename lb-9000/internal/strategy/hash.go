@@ -0,0 +1,198 @@
+package strategy
+
+import (
+	"fmt"
+	"lb-9000/lb-9000/internal/backend"
+	"lb-9000/lb-9000/internal/store"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const defaultReplicas = 100
+
+// keyFunc extracts the attribute the requests are hashed on, an empty key means there is none
+type keyFunc func(request *http.Request) string
+
+type consistentHash struct {
+	key      keyFunc
+	replicas int
+	fallback Strategy
+
+	lock sync.Mutex
+	ring *ring
+}
+
+// ring is a hash ring with replicas virtual nodes per backend,
+// so adding or removing a backend only moves the keys of its own nodes
+type ring struct {
+	members string
+	hashes  []uint64
+	owners  map[uint64]string
+}
+
+type HashParams struct {
+	// Key is one of "header:<name>", "cookie:<name>", "path:<segment>" (starting at 1) or "ip"
+	Key string
+	// Replicas is the number of virtual nodes per backend, 100 by default
+	Replicas int
+}
+
+// ConsistentHash sends requests with the same key to the same backend as long as it is available.
+// Requests without a key are elected by fill holes.
+func ConsistentHash(params HashParams) (Strategy, error) {
+	keyFn, err := parseKey(params.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Replicas < 0 {
+		return nil, fmt.Errorf("hash replicas must not be negative, got %d", params.Replicas)
+	}
+
+	if params.Replicas == 0 {
+		params.Replicas = defaultReplicas
+	}
+
+	return &consistentHash{
+		key:      keyFn,
+		replicas: params.Replicas,
+		fallback: FillHoles(),
+	}, nil
+}
+
+func (c *consistentHash) Elect(request *http.Request, store store.Store) (*backend.Backend, error) {
+	key := c.key(request)
+	if key == "" {
+		return c.fallback.Elect(request, store)
+	}
+
+	backends, err := store.All(request.Context())
+	if err != nil {
+		return nil, fmt.Errorf("getting all backends: %w", err)
+	}
+
+	if len(backends) == 0 {
+		return nil, nil
+	}
+
+	byID := make(map[string]*backend.Backend, len(backends))
+	for _, b := range backends {
+		byID[b.URL()] = b
+	}
+
+	r := c.ringFor(byID)
+
+	hash := xxhash.Sum64String(key)
+	start, _ := slices.BinarySearch(r.hashes, hash)
+
+	// walk clockwise until an available backend is found,
+	// unavailable backends are kept on the ring so the other keys do not move
+	for i := range len(r.hashes) {
+		owner := byID[r.owners[r.hashes[(start+i)%len(r.hashes)]]]
+		if owner.Available() {
+			return owner, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (c *consistentHash) ringFor(backends map[string]*backend.Backend) *ring {
+	ids := make([]string, 0, len(backends))
+	for id := range backends {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	members := strings.Join(ids, ",")
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.ring != nil && c.ring.members == members {
+		return c.ring
+	}
+
+	r := &ring{
+		members: members,
+		hashes:  make([]uint64, 0, len(ids)*c.replicas),
+		owners:  make(map[uint64]string, len(ids)*c.replicas),
+	}
+
+	for _, id := range ids {
+		for replica := range c.replicas {
+			hash := xxhash.Sum64String(id + "#" + strconv.Itoa(replica))
+			if _, taken := r.owners[hash]; taken {
+				continue
+			}
+
+			r.hashes = append(r.hashes, hash)
+			r.owners[hash] = id
+		}
+	}
+
+	slices.Sort(r.hashes)
+
+	c.ring = r
+
+	return r
+}
+
+func parseKey(spec string) (keyFunc, error) {
+	kind, name, _ := strings.Cut(spec, ":")
+
+	switch kind {
+	case "header":
+		if name == "" {
+			return nil, fmt.Errorf("hash key '%s' is missing the header name", spec)
+		}
+
+		return func(request *http.Request) string {
+			return request.Header.Get(name)
+		}, nil
+	case "cookie":
+		if name == "" {
+			return nil, fmt.Errorf("hash key '%s' is missing the cookie name", spec)
+		}
+
+		return func(request *http.Request) string {
+			cookie, err := request.Cookie(name)
+			if err != nil {
+				return ""
+			}
+
+			return cookie.Value
+		}, nil
+	case "path":
+		segment, err := strconv.Atoi(name)
+		if err != nil || segment < 1 {
+			return nil, fmt.Errorf("hash key '%s' needs a path segment starting at 1", spec)
+		}
+
+		return func(request *http.Request) string {
+			segments := strings.Split(strings.Trim(request.URL.Path, "/"), "/")
+			if segment > len(segments) {
+				return ""
+			}
+
+			return segments[segment-1]
+		}, nil
+	case "ip":
+		return func(request *http.Request) string {
+			host, _, err := net.SplitHostPort(request.RemoteAddr)
+			if err != nil {
+				return request.RemoteAddr
+			}
+
+			return host
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown hash key '%s'", spec)
+	}
+}
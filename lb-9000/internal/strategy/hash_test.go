@@ -0,0 +1,91 @@
+package strategy
+
+import (
+	"context"
+	"lb-9000/lb-9000/internal/backend"
+	"lb-9000/lb-9000/internal/store/memory"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsistentHash(t *testing.T) {
+	ctx := context.Background()
+	backendStore := memory.New(slog.Default())
+
+	for _, id := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		assert.NoError(t, backendStore.Add(ctx, backend.NewBackend(id, id)))
+	}
+
+	strategy, err := ConsistentHash(HashParams{Key: "header:X-Job-ID"})
+	assert.NoError(t, err)
+
+	electAll := func() map[string]string {
+		result := make(map[string]string)
+
+		for i := range 1000 {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("X-Job-ID", strconv.Itoa(i))
+
+			elected, err := strategy.Elect(request, backendStore)
+			assert.NoError(t, err)
+
+			result[strconv.Itoa(i)] = elected.URL()
+		}
+
+		return result
+	}
+
+	before := electAll()
+	assert.Equal(t, before, electAll())
+
+	assert.NoError(t, backendStore.Add(ctx, backend.NewBackend("10.0.0.4", "10.0.0.4")))
+
+	after := electAll()
+
+	moved := 0
+	for key, owner := range after {
+		if before[key] != owner {
+			moved++
+			// keys only move to the new backend
+			assert.Equal(t, "10.0.0.4", owner)
+		}
+	}
+
+	assert.Greater(t, moved, 100)
+	assert.Less(t, moved, 400)
+
+	// keys of an unavailable backend move to the next one on the ring, the others stay
+	assert.NoError(t, backendStore.Update(ctx, "10.0.0.4", func(b *backend.Backend) {
+		b.SetHealthy(false)
+	}))
+
+	assert.Equal(t, before, electAll())
+}
+
+func TestParseKey(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/jobs/42/result", nil)
+	request.RemoteAddr = "192.168.0.1:1234"
+	request.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+
+	for spec, expected := range map[string]string{
+		"path:2":         "42",
+		"path:4":         "",
+		"ip":             "192.168.0.1",
+		"cookie:session": "abc",
+		"header:X-None":  "",
+	} {
+		keyFn, err := parseKey(spec)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, keyFn(request), spec)
+	}
+
+	for _, spec := range []string{"", "header", "path:0", "query:id"} {
+		_, err := parseKey(spec)
+		assert.Error(t, err, spec)
+	}
+}
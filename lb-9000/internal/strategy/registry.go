@@ -0,0 +1,63 @@
+package strategy
+
+import (
+	"fmt"
+	"lb-9000/lb-9000/internal/config"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Factory builds a strategy from the parameters it reads from the configuration
+type Factory func(cfg *config.Config) (Strategy, error)
+
+var registry = map[string]Factory{
+	"fill-holes": func(*config.Config) (Strategy, error) {
+		return FillHoles(), nil
+	},
+	"round-robin": func(*config.Config) (Strategy, error) {
+		return RoundRobin(), nil
+	},
+	"weighted-least-connections": func(*config.Config) (Strategy, error) {
+		return WeightedLeastConnections(), nil
+	},
+	"p2c": func(cfg *config.Config) (Strategy, error) {
+		return PowerOfTwoChoices(P2CParams{
+			Choices: cfg.P2CChoices,
+		})
+	},
+	"consistent-hash": func(cfg *config.Config) (Strategy, error) {
+		return ConsistentHash(HashParams{
+			Key:      cfg.HashKey,
+			Replicas: cfg.HashReplicas,
+		})
+	},
+}
+
+// Register makes a strategy available by name, it is meant to be called from init functions
+func Register(name string, factory Factory) {
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("strategy '%s' is already registered", name))
+	}
+
+	registry[name] = factory
+}
+
+// Get builds the strategy named by the configuration
+func Get(cfg *config.Config) (Strategy, error) {
+	factory, ok := registry[cfg.Strategy]
+	if !ok {
+		return nil, fmt.Errorf(
+			"unknown strategy '%s', expected one of: %s",
+			cfg.Strategy,
+			strings.Join(slices.Sorted(maps.Keys(registry)), ", "),
+		)
+	}
+
+	strategy, err := factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("configuring strategy '%s': %w", cfg.Strategy, err)
+	}
+
+	return strategy, nil
+}
@@ -1,16 +1,19 @@
 package strategy
 
 import (
-	"context"
 	"fmt"
 	"lb-9000/lb-9000/internal/backend"
 	"lb-9000/lb-9000/internal/store"
 	"math"
+	"net/http"
+	"slices"
+	"strings"
 	"sync/atomic"
 )
 
 type Strategy interface {
-	Elect(ctx context.Context, store store.Store) (*backend.Backend, error)
+	// Elect picks the backend for the request, nil means no backend is available
+	Elect(request *http.Request, store store.Store) (*backend.Backend, error)
 }
 
 type roundRobin struct {
@@ -18,16 +21,29 @@ type roundRobin struct {
 }
 
 func RoundRobin() Strategy {
-	return roundRobin{}
+	return &roundRobin{}
 }
 
-func (r roundRobin) Elect(ctx context.Context, store store.Store) (*backend.Backend, error) {
-	backends, err := store.All(ctx)
+func (r *roundRobin) Elect(request *http.Request, store store.Store) (*backend.Backend, error) {
+	backends, err := store.All(request.Context())
 	if err != nil {
 		return nil, fmt.Errorf("getting all backends: %w", err)
 	}
 
-	return backends[atomic.AddUint64(&r.currentIndex, 1)%uint64(len(backends))], nil
+	available := slices.DeleteFunc(backends, func(b *backend.Backend) bool {
+		return !b.Available()
+	})
+
+	if len(available) == 0 {
+		return nil, nil
+	}
+
+	// stores do not guarantee any order, so it has to be stable for the rotation to work
+	slices.SortFunc(available, func(a, b *backend.Backend) int {
+		return strings.Compare(a.URL(), b.URL())
+	})
+
+	return available[atomic.AddUint64(&r.currentIndex, 1)%uint64(len(available))], nil
 }
 
 func FillHoles() Strategy {
@@ -36,18 +52,21 @@ func FillHoles() Strategy {
 
 type fillHolesStrategy struct{}
 
-func (f fillHolesStrategy) Elect(ctx context.Context, store store.Store) (*backend.Backend, error) {
+func (f fillHolesStrategy) Elect(request *http.Request, store store.Store) (*backend.Backend, error) {
 	var (
 		minCount   int64 = math.MaxInt64
 		minBackend *backend.Backend
 	)
 
-	iterator, err := store.Iterate(ctx)
+	iterator, err := store.Iterate(request.Context())
 	if err != nil {
 		return nil, fmt.Errorf("iterating backends: %w", err)
 	}
 
 	for instance := range iterator {
+		if !instance.Available() {
+			continue
+		}
 		if count := instance.Count(); count < minCount {
 			minCount = count
 			minBackend = instance
@@ -59,3 +78,93 @@ func (f fillHolesStrategy) Elect(ctx context.Context, store store.Store) (*backe
 
 	return minBackend, nil
 }
+
+// WeightedLeastConnections elects the backend with the fewest requests relative to its weight
+func WeightedLeastConnections() Strategy {
+	return weightedLeastConnections{}
+}
+
+type weightedLeastConnections struct{}
+
+func (w weightedLeastConnections) Elect(request *http.Request, store store.Store) (*backend.Backend, error) {
+	var (
+		minScore   = math.Inf(1)
+		minBackend *backend.Backend
+	)
+
+	iterator, err := store.Iterate(request.Context())
+	if err != nil {
+		return nil, fmt.Errorf("iterating backends: %w", err)
+	}
+
+	for instance := range iterator {
+		if !instance.Available() {
+			continue
+		}
+		if score := float64(instance.Count()) / float64(instance.Weight()); score < minScore {
+			minScore = score
+			minBackend = instance
+		}
+		if minScore == 0 {
+			break
+		}
+	}
+
+	return minBackend, nil
+}
+
+// p2cAttempts is how often random backends are sampled before falling back to a full scan,
+// which only happens when most of the pool is not available
+const p2cAttempts = 3
+
+type P2CParams struct {
+	// Choices is the number of backends sampled per request, 2 by default
+	Choices int
+}
+
+// PowerOfTwoChoices samples random backends and elects the one with the fewest requests,
+// so the full set of backends does not have to be loaded for every request
+func PowerOfTwoChoices(params P2CParams) (Strategy, error) {
+	if params.Choices == 0 {
+		params.Choices = 2
+	}
+
+	if params.Choices < 2 {
+		return nil, fmt.Errorf("p2c needs at least 2 choices, got %d", params.Choices)
+	}
+
+	return powerOfTwoChoices{choices: params.Choices}, nil
+}
+
+type powerOfTwoChoices struct {
+	choices int
+}
+
+func (p powerOfTwoChoices) Elect(request *http.Request, store store.Store) (*backend.Backend, error) {
+	for range p2cAttempts {
+		candidates, err := store.Sample(request.Context(), p.choices)
+		if err != nil {
+			return nil, fmt.Errorf("sampling backends: %w", err)
+		}
+
+		if len(candidates) == 0 {
+			return nil, nil
+		}
+
+		var elected *backend.Backend
+		for _, candidate := range candidates {
+			if !candidate.Available() {
+				continue
+			}
+			if elected == nil || candidate.Count() < elected.Count() {
+				elected = candidate
+			}
+		}
+
+		if elected != nil {
+			return elected, nil
+		}
+	}
+
+	return FillHoles().Elect(request, store)
+}
@@ -0,0 +1,94 @@
+package strategy
+
+import (
+	"context"
+	"lb-9000/lb-9000/internal/backend"
+	"lb-9000/lb-9000/internal/config"
+	"lb-9000/lb-9000/internal/store/memory"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWeightedLeastConnections(t *testing.T) {
+	ctx := context.Background()
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	backendStore := memory.New(slog.Default())
+
+	small := backend.NewBackend("10.0.0.1", "small")
+	small.AddRequests(2)
+
+	big := backend.NewBackend("10.0.0.2", "big")
+	big.SetWeight(4)
+	big.AddRequests(6)
+
+	assert.NoError(t, backendStore.Add(ctx, small))
+	assert.NoError(t, backendStore.Add(ctx, big))
+
+	elected, err := WeightedLeastConnections().Elect(request, backendStore)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", elected.URL())
+
+	assert.NoError(t, backendStore.AddRequests(ctx, "10.0.0.2", 3))
+
+	elected, err = WeightedLeastConnections().Elect(request, backendStore)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", elected.URL())
+
+	elected, err = FillHoles().Elect(request, backendStore)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", elected.URL())
+}
+
+func TestPowerOfTwoChoices(t *testing.T) {
+	ctx := context.Background()
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	backendStore := memory.New(slog.Default())
+
+	busy := backend.NewBackend("10.0.0.1", "busy")
+	busy.AddRequests(5)
+
+	idle := backend.NewBackend("10.0.0.2", "idle")
+
+	unhealthy := backend.NewBackend("10.0.0.3", "unhealthy")
+	unhealthy.SetHealthy(false)
+
+	assert.NoError(t, backendStore.Add(ctx, busy))
+	assert.NoError(t, backendStore.Add(ctx, idle))
+	assert.NoError(t, backendStore.Add(ctx, unhealthy))
+
+	strategy, err := PowerOfTwoChoices(P2CParams{})
+	assert.NoError(t, err)
+
+	for range 20 {
+		elected, err := strategy.Elect(request, backendStore)
+		assert.NoError(t, err)
+		assert.NotEqual(t, "10.0.0.3", elected.URL())
+	}
+
+	assert.NoError(t, backendStore.Remove(ctx, "10.0.0.1"))
+
+	for range 20 {
+		elected, err := strategy.Elect(request, backendStore)
+		assert.NoError(t, err)
+		assert.Equal(t, "10.0.0.2", elected.URL())
+	}
+}
+
+func TestGet(t *testing.T) {
+	strategy, err := Get(&config.Config{Strategy: "fill-holes"})
+	assert.NoError(t, err)
+	assert.Equal(t, FillHoles(), strategy)
+
+	_, err = Get(&config.Config{Strategy: "random"})
+	assert.ErrorContains(t, err, "unknown strategy 'random'")
+
+	_, err = Get(&config.Config{Strategy: "consistent-hash", HashKey: "query:id"})
+	assert.ErrorContains(t, err, "configuring strategy 'consistent-hash'")
+
+	_, err = Get(&config.Config{Strategy: "p2c", P2CChoices: 1})
+	assert.Error(t, err)
+}
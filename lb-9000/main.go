@@ -1,14 +1,25 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"lb-9000/lb-9000/internal/accesslog"
+	"lb-9000/lb-9000/internal/admin"
+	"lb-9000/lb-9000/internal/affinity"
 	appconfig "lb-9000/lb-9000/internal/config"
 	"lb-9000/lb-9000/internal/election"
+	"lb-9000/lb-9000/internal/health"
+	"lb-9000/lb-9000/internal/metrics"
 	"lb-9000/lb-9000/internal/orchestration"
+	"lb-9000/lb-9000/internal/outlier"
 	"lb-9000/lb-9000/internal/pool"
 	"lb-9000/lb-9000/internal/proxy"
+	"lb-9000/lb-9000/internal/queue"
+	"lb-9000/lb-9000/internal/requestid"
+	"lb-9000/lb-9000/internal/router"
 	"lb-9000/lb-9000/internal/store"
 	"lb-9000/lb-9000/internal/strategy"
+	"lb-9000/lb-9000/internal/tracing"
 	"lb-9000/lb-9000/internal/utils"
 	"log/slog"
 	"os"
@@ -28,30 +39,126 @@ func run() error {
 		return fmt.Errorf("parsing config: %w", err)
 	}
 
-	logger := slog.Default()
+	// records logged while handling a request carry its id
+	logger := slog.New(requestid.NewHandler(slog.Default().Handler()))
 
-	orchestrator, err := orchestration.NewKubernetes(logger, appConfig)
+	shutdownTracing, err := tracing.Setup(context.Background(), appConfig)
 	if err != nil {
-		return fmt.Errorf("creating orchestrator: %w", err)
+		return fmt.Errorf("setting up tracing: %w", err)
 	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.Error("error flushing spans", "error", err)
+		}
+	}()
+
+	routes, err := appconfig.ParseRoutes(appConfig)
+	if err != nil {
+		return fmt.Errorf("parsing routes: %w", err)
+	}
+
+	orchestrators := make([]orchestration.Orchestration, len(routes))
+	for i, route := range routes {
+		if orchestrators[i], err = orchestration.NewKubernetes(logger.With("route", route.Name), route.Config); err != nil {
+			return fmt.Errorf("creating orchestrator of route '%s': %w", route.Name, err)
+		}
+	}
+
+	instanceID := orchestrators[0].InstanceID()
 
 	elector := election.NewElector(
-		orchestrator.InstanceID(),
+		instanceID,
 		logger,
 		utils.GetRedisClient(appConfig),
 		appConfig.LockTTL,
 	)
+	go elector.Loop()
+
+	metrics.RegisterGauge("leader", "1 when this instance is the leader.", nil, func() float64 {
+		return metrics.Bool(elector.IsLeader())
+	})
+
+	routeHandlers := make([]*router.Route, 0, len(routes))
+	stores := map[string]store.Store{}
+	queues := map[string]admin.Queue{}
+
+	for i, route := range routes {
+		routeHandler, backendStore, err := newRoute(route, orchestrators[i], elector, logger.With("route", route.Name))
+		if err != nil {
+			return fmt.Errorf("creating route '%s': %w", route.Name, err)
+		}
+
+		routeHandlers = append(routeHandlers, routeHandler)
+		stores[route.Name] = backendStore
+		queues[route.Name] = routeHandler.Pool
+	}
+
+	accessLog, err := accesslog.New(appConfig, instanceID)
+	if err != nil {
+		return fmt.Errorf("creating access log: %w", err)
+	}
+
+	proxy.Start(
+		router.New(routeHandlers, appConfig),
+		admin.New(stores, queues, elector, logger, appConfig),
+		accessLog,
+		strconv.Itoa(appConfig.ContainerPort),
+	)
+
+	return nil
+}
+
+// newRoute builds the pool of a route with its own store, strategy and health checks
+func newRoute(
+	route appconfig.Route,
+	orchestrator orchestration.Orchestration,
+	elector *election.Elector,
+	logger *slog.Logger,
+) (*router.Route, store.Store, error) {
+	// contributions are only tracked while a lease lets other instances reclaim them
+	instanceID := orchestrator.InstanceID()
+	if route.Config.CounterLeaseTTL <= 0 {
+		instanceID = ""
+	}
+
+	sharedStore := store.Get(route.Config, instanceID, logger)
+
+	// the lease must be held before this instance counts requests
+	reconciler := store.NewReconciler(sharedStore, elector, logger, route.Config)
+	if err := reconciler.Renew(context.Background()); err != nil {
+		return nil, nil, fmt.Errorf("renewing counter lease: %w", err)
+	}
+	go reconciler.Loop()
+
+	backendStore := metrics.InstrumentStore(sharedStore)
+	metrics.RegisterBackends(route.Name, backendStore, logger)
+
+	electionStrategy, err := strategy.Get(route.Config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating strategy: %w", err)
+	}
+
+	detector := outlier.NewDetector(backendStore, logger, route.Config)
+	go detector.Loop()
 
 	podPool := pool.New(
-		store.Get(appConfig, logger),
-		strategy.FillHoles(),
+		backendStore,
+		electionStrategy,
 		orchestrator,
 		elector,
+		detector,
+		affinity.New(route.Config),
+		queue.New(route.Config),
 		logger,
-		appConfig.RefreshRate,
+		route.Config,
 	)
 
-	proxy.Start(podPool, strconv.Itoa(appConfig.ContainerPort))
+	metrics.RegisterGauge("queue_depth", "Requests waiting for a backend with capacity.", map[string]string{"route": route.Name}, func() float64 {
+		depth, _ := podPool.QueueDepth()
+		return float64(depth)
+	})
 
-	return nil
+	go health.NewChecker(backendStore, orchestrator, elector, logger, route.Config).Loop()
+
+	return router.NewRoute(route.Name, route.Match, podPool), backendStore, nil
 }